@@ -0,0 +1,328 @@
+// Package apitest provides a stand-in for the Logsight API which can be used in tests.
+package apitest
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+)
+
+const (
+	LoginPath = "/api/v1/auth/login"
+	LogsPath  = "/api/v1/logs/singles"
+
+	IdempotencyKeyHeader = "Idempotency-Key"
+	NdjsonContentType    = "application/x-ndjson"
+	ProblemContentType   = "application/problem+json"
+
+	// maxLineSize limits the size of a single log in a ndjson batch
+	maxLineSize = 1024 * 1024
+)
+
+// Log is a log as it is received by the stand-in server
+type Log struct {
+	Timestamp string            `json:"timestamp"`
+	Message   string            `json:"message"`
+	Level     string            `json:"level"`
+	Tags      map[string]string `json:"tags"`
+}
+
+// Server is a stand-in for the Logsight API. It implements the login and log endpoints and records all received
+// logs. Requests to the log endpoint are authorized by basic auth if Email and Password are set and by an
+// Authorization header if Token is set. If none of them is set, all requests are accepted.
+// Batches are either a json array or, with the ndjson content type, one log per line. Lines of a ndjson batch which
+// are no valid logs are skipped and reported in the receipt. Array batches with invalid logs are rejected as a whole
+// with a problem+json body which lists the invalid logs by index. Batches with an Idempotency-Key header are ingested
+// only once. Repeated batches are answered with the receipt or the problem of the first request.
+type Server struct {
+	*httptest.Server
+
+	Email    string
+	Password string
+	Token    string
+	UserId   uuid.UUID
+
+	mutex         sync.Mutex
+	logs          []Log
+	failStatus    int
+	lostResponses int
+	receipts      map[string]receipt
+	duplicates    int
+	// rejected are the problems of rejected batches by their idempotency key
+	rejected map[string][]fieldError
+}
+
+type receipt struct {
+	ReceiptId uuid.UUID   `json:"receiptId"`
+	LogsCount int         `json:"logsCount"`
+	BatchId   uuid.UUID   `json:"batchId"`
+	Status    int         `json:"status"`
+	Errors    []lineError `json:"errors,omitempty"`
+}
+
+// problem is an error body in the format of RFC 7807
+type problem struct {
+	Type   string       `json:"type"`
+	Title  string       `json:"title"`
+	Status int          `json:"status"`
+	Detail string       `json:"detail"`
+	Errors []fieldError `json:"errors"`
+}
+
+type fieldError struct {
+	Index  int    `json:"index"`
+	Field  string `json:"field"`
+	Detail string `json:"detail"`
+}
+
+type lineError struct {
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+// NewServer starts a plain http stand-in server.
+func NewServer() *Server {
+	s := NewUnstartedServer()
+	s.Start()
+	return s
+}
+
+// NewUnstartedServer returns a stand-in server which is not started yet. This allows to configure TLS before
+// calling StartTLS.
+func NewUnstartedServer() *Server {
+	s := &Server{UserId: uuid.New(), receipts: map[string]receipt{}, rejected: map[string][]fieldError{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc(LoginPath, s.handleLogin)
+	mux.HandleFunc(LogsPath, s.handleLogs)
+	s.Server = httptest.NewUnstartedServer(mux)
+	return s
+}
+
+// Logs returns a copy of all logs received so far.
+func (s *Server) Logs() []Log {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	logs := make([]Log, len(s.logs))
+	copy(logs, s.logs)
+	return logs
+}
+
+// FailWith makes the log endpoint respond with the status code instead of accepting logs. A status of 0 restores
+// the normal behaviour.
+func (s *Server) FailWith(status int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.failStatus = status
+}
+
+// LoseResponses makes the log endpoint ingest the next n batches but answer with a gateway timeout, as if the
+// response got lost on the way back to the client.
+func (s *Server) LoseResponses(n int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.lostResponses = n
+}
+
+// Duplicates returns the number of batches which were not ingested because their idempotency key was known.
+func (s *Server) Duplicates() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.duplicates
+}
+
+func (s *Server) handleLogin(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		s.writeError(res, http.StatusMethodNotAllowed, fmt.Sprintf("method %v not allowed", req.Method))
+		return
+	}
+	var loginReq struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&loginReq); err != nil {
+		s.writeError(res, http.StatusBadRequest, err.Error())
+		return
+	}
+	if loginReq.Email != s.Email || loginReq.Password != s.Password {
+		s.writeError(res, http.StatusUnauthorized, "invalid credentials")
+		return
+	}
+	s.writeJson(res, http.StatusOK, map[string]interface{}{
+		"token": "stand-in-token",
+		"user":  map[string]interface{}{"userId": s.UserId, "email": s.Email},
+	})
+}
+
+func (s *Server) handleLogs(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		s.writeError(res, http.StatusMethodNotAllowed, fmt.Sprintf("method %v not allowed", req.Method))
+		return
+	}
+	if !s.authorized(req) {
+		s.writeError(res, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	s.mutex.Lock()
+	failStatus := s.failStatus
+	s.mutex.Unlock()
+	if failStatus != 0 {
+		s.writeError(res, failStatus, http.StatusText(failStatus))
+		return
+	}
+	var logs []Log
+	var lineErrors []lineError
+	if strings.HasPrefix(req.Header.Get("Content-Type"), NdjsonContentType) {
+		var err error
+		logs, lineErrors, err = decodeNdjson(req)
+		if err != nil {
+			s.writeError(res, http.StatusBadRequest, err.Error())
+			return
+		}
+	} else if err := json.NewDecoder(req.Body).Decode(&logs); err != nil {
+		s.writeError(res, http.StatusBadRequest, err.Error())
+		return
+	} else if fieldErrors := s.validateBatch(req.Header.Get(IdempotencyKeyHeader), logs); len(fieldErrors) > 0 {
+		s.writeProblem(res, fieldErrors)
+		return
+	}
+
+	status, body := s.ingest(req.Header.Get(IdempotencyKeyHeader), logs, lineErrors)
+	s.writeJson(res, status, body)
+}
+
+// decodeNdjson decodes one log per line. Lines which are no valid logs are returned as errors, empty lines are
+// skipped.
+func decodeNdjson(req *http.Request) ([]Log, []lineError, error) {
+	var logs []Log
+	var lineErrors []lineError
+	scanner := bufio.NewScanner(req.Body)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var log Log
+		if err := json.Unmarshal(scanner.Bytes(), &log); err != nil {
+			lineErrors = append(lineErrors, lineError{Line: line, Message: err.Error()})
+			continue
+		}
+		if err := log.validate(); err != nil {
+			lineErrors = append(lineErrors, lineError{Line: line, Message: err.Error()})
+			continue
+		}
+		logs = append(logs, log)
+	}
+	return logs, lineErrors, scanner.Err()
+}
+
+func (l Log) validate() error {
+	if fieldErr := l.invalidField(); fieldErr != nil {
+		return fmt.Errorf("%v %v", fieldErr.Field, fieldErr.Detail)
+	}
+	return nil
+}
+
+func (l Log) invalidField() *fieldError {
+	switch {
+	case l.Timestamp == "":
+		return &fieldError{Field: "timestamp", Detail: "is required"}
+	case l.Message == "":
+		return &fieldError{Field: "message", Detail: "is required"}
+	case l.Level == "":
+		return &fieldError{Field: "level", Detail: "is required"}
+	}
+	return nil
+}
+
+// validateBatch returns the invalid logs of the batch. Like the API, it replays the problem of a rejected batch if its
+// idempotency key is used again, regardless of the logs.
+func (s *Server) validateBatch(idempotencyKey string, logs []Log) []fieldError {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if fieldErrors, known := s.rejected[idempotencyKey]; known {
+		return fieldErrors
+	}
+	fieldErrors := validateLogs(logs)
+	if len(fieldErrors) > 0 && idempotencyKey != "" {
+		s.rejected[idempotencyKey] = fieldErrors
+	}
+	return fieldErrors
+}
+
+// validateLogs returns the first invalid field of each invalid log
+func validateLogs(logs []Log) []fieldError {
+	var fieldErrors []fieldError
+	for i, log := range logs {
+		if fieldErr := log.invalidField(); fieldErr != nil {
+			fieldErr.Index = i
+			fieldErrors = append(fieldErrors, *fieldErr)
+		}
+	}
+	return fieldErrors
+}
+
+func (s *Server) ingest(idempotencyKey string, logs []Log, lineErrors []lineError) (int, interface{}) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	r, known := s.receipts[idempotencyKey]
+	if known {
+		s.duplicates++
+	} else {
+		r = receipt{ReceiptId: uuid.New(), LogsCount: len(logs), BatchId: uuid.New(), Errors: lineErrors}
+		if batchId, err := uuid.Parse(idempotencyKey); err == nil {
+			r.BatchId = batchId
+		}
+		if idempotencyKey != "" {
+			s.receipts[idempotencyKey] = r
+		}
+		s.logs = append(s.logs, logs...)
+	}
+
+	if s.lostResponses > 0 {
+		s.lostResponses--
+		return http.StatusGatewayTimeout, map[string]string{"message": http.StatusText(http.StatusGatewayTimeout)}
+	}
+	return http.StatusOK, r
+}
+
+func (s *Server) authorized(req *http.Request) bool {
+	if s.Email == "" && s.Token == "" {
+		return true
+	}
+	if email, password, ok := req.BasicAuth(); ok && s.Email != "" {
+		return email == s.Email && password == s.Password
+	}
+	if s.Token != "" {
+		authorization := req.Header.Get("Authorization")
+		return strings.HasSuffix(authorization, " "+s.Token)
+	}
+	return false
+}
+
+func (s *Server) writeJson(res http.ResponseWriter, status int, body interface{}) {
+	res.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	res.WriteHeader(status)
+	_ = json.NewEncoder(res).Encode(body)
+}
+
+func (s *Server) writeProblem(res http.ResponseWriter, fieldErrors []fieldError) {
+	res.Header().Set("Content-Type", ProblemContentType)
+	res.WriteHeader(http.StatusBadRequest)
+	_ = json.NewEncoder(res).Encode(problem{
+		Type:   "about:blank",
+		Title:  "invalid logs",
+		Status: http.StatusBadRequest,
+		Detail: fmt.Sprintf("%v logs of the batch are invalid", len(fieldErrors)),
+		Errors: fieldErrors,
+	})
+}
+
+func (s *Server) writeError(res http.ResponseWriter, status int, message string) {
+	s.writeJson(res, status, map[string]string{"message": message})
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+)
+
+const DefaultTokenScheme = "Bearer"
+
+// Authenticator adds credentials to a request before it is sent to the API.
+type Authenticator interface {
+	Authenticate(req *http.Request) error
+}
+
+// BasicAuthenticator authenticates requests with the email and password of a User.
+type BasicAuthenticator struct {
+	User *User
+}
+
+func (ba BasicAuthenticator) Authenticate(req *http.Request) error {
+	if ba.User == nil {
+		return fmt.Errorf("basic authentication failed. no user is set")
+	}
+	req.SetBasicAuth(ba.User.Email, ba.User.Password)
+	return nil
+}
+
+func (ba BasicAuthenticator) String() string {
+	return fmt.Sprintf("basic authentication for user %v", ba.User)
+}
+
+// TokenAuthenticator authenticates requests with a static bearer token or API key. The token is sent in the
+// Authorization header, prefixed by the Scheme.
+type TokenAuthenticator struct {
+	Token  string
+	Scheme string
+}
+
+func (ta TokenAuthenticator) Authenticate(req *http.Request) error {
+	if ta.Token == "" {
+		return fmt.Errorf("token authentication failed. token is empty")
+	}
+	scheme := ta.Scheme
+	if scheme == "" {
+		scheme = DefaultTokenScheme
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("%v %v", scheme, ta.Token))
+	return nil
+}
+
+func (ta TokenAuthenticator) String() string {
+	return fmt.Sprintf("token authentication with scheme %v", ta.Scheme)
+}
+
+// NoneAuthenticator leaves requests unauthenticated, e.g. if authentication is done by a gateway.
+type NoneAuthenticator struct{}
+
+func (na NoneAuthenticator) Authenticate(*http.Request) error {
+	return nil
+}
+
+func (na NoneAuthenticator) String() string {
+	return "no authentication"
+}
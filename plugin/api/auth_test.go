@@ -0,0 +1,82 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestBaseApi_BuildAuthenticatedRequest(t *testing.T) {
+	parsedUrl, _ := url.Parse("https://test.org:8080")
+	user := &User{Email: "hari.seldon@fundation.gal", Password: "foundation_rulez"}
+	basicReq, _ := http.NewRequest(http.MethodGet, parsedUrl.String(), nil)
+	basicReq.SetBasicAuth(user.Email, user.Password)
+
+	tests := []struct {
+		name          string
+		authenticator Authenticator
+		want          string
+		wantErr       bool
+	}{
+		{
+			name:          "pass nil authenticator",
+			authenticator: nil,
+			want:          "",
+			wantErr:       false,
+		},
+		{
+			name:          "pass none",
+			authenticator: NoneAuthenticator{},
+			want:          "",
+			wantErr:       false,
+		},
+		{
+			name:          "pass basic",
+			authenticator: BasicAuthenticator{User: user},
+			want:          basicReq.Header.Get("Authorization"),
+			wantErr:       false,
+		},
+		{
+			name:          "pass token default scheme",
+			authenticator: TokenAuthenticator{Token: "secret-token"},
+			want:          "Bearer secret-token",
+			wantErr:       false,
+		},
+		{
+			name:          "pass token api key scheme",
+			authenticator: TokenAuthenticator{Token: "secret-key", Scheme: "ApiKey"},
+			want:          "ApiKey secret-key",
+			wantErr:       false,
+		},
+		{
+			name:          "fail basic without user",
+			authenticator: BasicAuthenticator{},
+			wantErr:       true,
+		},
+		{
+			name:          "fail empty token",
+			authenticator: TokenAuthenticator{},
+			wantErr:       true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ba := &BaseApi{
+				HttpClient:    http.DefaultClient,
+				Url:           parsedUrl,
+				Authenticator: tt.authenticator,
+			}
+			got, err := ba.BuildAuthenticatedRequest(http.MethodGet, parsedUrl.String(), nil)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("BuildAuthenticatedRequest() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if gotHeader := got.Header.Get("Authorization"); gotHeader != tt.want {
+				t.Errorf("BuildAuthenticatedRequest() got Authorization = %v, want %v", gotHeader, tt.want)
+			}
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"mime"
 	"net/http"
 	"net/url"
 )
@@ -13,6 +14,8 @@ import (
 type BaseApi struct {
 	HttpClient *http.Client
 	Url        *url.URL
+	// Authenticator is used by BuildAuthenticatedRequest. Requests stay unauthenticated if it is nil.
+	Authenticator Authenticator
 }
 
 func (ba *BaseApi) BuildRequest(method string, url string, body interface{}) (*http.Request, error) {
@@ -40,6 +43,20 @@ func (ba *BaseApi) BuildRequestWithBasicAuth(method string, url string, body int
 	return req, nil
 }
 
+func (ba *BaseApi) BuildAuthenticatedRequest(method string, url string, body interface{}) (*http.Request, error) {
+	req, err := ba.BuildRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if ba.Authenticator == nil {
+		return req, nil
+	}
+	if err := ba.Authenticator.Authenticate(req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
 func (ba *BaseApi) addJsonContentTypeHeader(req *http.Request) {
 	key := "Content-Type"
 	value := "application/json; charset=UTF-8"
@@ -72,10 +89,14 @@ func (ba *BaseApi) encode(body interface{}) (io.Reader, error) {
 	return bodyEnc, nil
 }
 
+// buildBody encodes the body as json. A json.RawMessage is already encoded and sent as it is.
 func (ba *BaseApi) buildBody(body interface{}) (io.Reader, error) {
 	if body == nil {
 		return nil, nil
 	}
+	if raw, ok := body.(json.RawMessage); ok {
+		return bytes.NewReader(raw), nil
+	}
 	bodyEnc, err := ba.encode(body)
 	if err != nil {
 		return nil, err
@@ -83,11 +104,70 @@ func (ba *BaseApi) buildBody(body interface{}) (io.Reader, error) {
 	return bodyEnc, nil
 }
 
+// ProblemContentType is the content type of error bodies in the format of RFC 7807
+const ProblemContentType = "application/problem+json"
+
+// Problem is an error body in the format of RFC 7807. Errors is an extension which lists the invalid logs of a batch
+// by their index.
+type Problem struct {
+	Type     string       `json:"type,omitempty"`
+	Title    string       `json:"title,omitempty"`
+	Status   int          `json:"status,omitempty"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Errors   []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError is the reason why the log at the Index of a batch is invalid. Field is empty if the log as a whole is
+// invalid.
+type FieldError struct {
+	Index  int    `json:"index"`
+	Field  string `json:"field,omitempty"`
+	Detail string `json:"detail"`
+}
+
+func (fe FieldError) reason() string {
+	if fe.Field == "" {
+		return fe.Detail
+	}
+	return fmt.Sprintf("%v: %v", fe.Field, fe.Detail)
+}
+
+// StatusError is returned if the API responds with an unexpected status code. Problem is set if the body is a
+// problem+json document.
+type StatusError struct {
+	StatusCode     int
+	ExpectedStatus int
+	Body           string
+	Problem        *Problem
+}
+
+func (se StatusError) Error() string {
+	if se.Body == "" {
+		return fmt.Sprintf("unexpected return code %v. %v was expected", se.StatusCode, se.ExpectedStatus)
+	}
+	return fmt.Sprintf("unexpected return code %v. %v was expected. error body: %v",
+		se.StatusCode, se.ExpectedStatus, se.Body)
+}
+
 func (ba *BaseApi) GetUnexpectedStatusError(resp *http.Response, expectedStatus int) error {
-	if respBytes, err := ba.toBytes(resp.Body); err != nil {
-		return fmt.Errorf("unexpected return code %v. %v was expected", resp.StatusCode, expectedStatus)
-	} else {
-		return fmt.Errorf("unexpected return code %v. %v was expected. error body: %v",
-			resp.StatusCode, expectedStatus, string(respBytes))
+	statusErr := StatusError{StatusCode: resp.StatusCode, ExpectedStatus: expectedStatus}
+	if respBytes, err := ba.toBytes(resp.Body); err == nil {
+		statusErr.Body = string(respBytes)
+		statusErr.Problem = parseProblem(resp.Header.Get("Content-Type"), respBytes)
+	}
+	return statusErr
+}
+
+// parseProblem returns the problem of a problem+json body and nil for all other bodies
+func parseProblem(contentType string, body []byte) *Problem {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType != ProblemContentType {
+		return nil
+	}
+	var problem Problem
+	if err := json.Unmarshal(body, &problem); err != nil {
+		return nil
 	}
+	return &problem
 }
@@ -1,19 +1,41 @@
 package api
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"github.com/google/uuid"
 	"io"
 	"net/http"
-	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 )
 
 const levelRegex = "^INFO$|^WARNING$|^WARN$|^FINER$|^FINE$|^DEBUG$|^ERROR$|^ERR$|^EXCEPTION$|^SEVERE$"
 const iso8601Regex = "^\\d{4}-\\d{2}-\\d{2}T\\d{2}:\\d{2}:\\d{2}(\\.\\d+)?(([+-]\\d{2}:\\d{2})|Z)?$"
 
+// IdempotencyKeyHeader carries the id of a LogBatch. The API ingests a batch only once per id.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// Formats in which batches are sent. FormatArray sends a json array of logs, FormatNdjson sends one json log per
+// line, which the API processes line by line and reports errors per line.
+const (
+	FormatArray  = "array"
+	FormatNdjson = "ndjson"
+
+	NdjsonContentType = "application/x-ndjson"
+)
+
 var (
 	postLogBatchConf = map[string]string{"method": "POST", "path": "/api/v1/logs/singles"}
+
+	// validLevels are the levels of levelRegex, which are looked up instead of matching the regex for every log
+	validLevels = map[string]bool{
+		"INFO": true, "WARNING": true, "WARN": true, "FINER": true, "FINE": true, "DEBUG": true, "ERROR": true,
+		"ERR": true, "EXCEPTION": true, "SEVERE": true,
+	}
 )
 
 // Log data structure used in LogBatch. It must comply with the
@@ -36,8 +58,7 @@ func (l *Log) ValidateLog() error {
 }
 
 func (l *Log) validateLevel() error {
-	reg := regexp.MustCompile(levelRegex)
-	if match := reg.MatchString(l.Level); match {
+	if validLevels[l.Level] {
 		return nil
 	} else {
 		return fmt.Errorf("invalid log level. must be one of %v", levelRegex)
@@ -45,49 +66,280 @@ func (l *Log) validateLevel() error {
 }
 
 func (l *Log) validateTimestamp() error {
-	reg := regexp.MustCompile(iso8601Regex)
-	if match := reg.MatchString(l.Timestamp); match {
+	if isISO8601(l.Timestamp) {
 		return nil
 	} else {
 		return fmt.Errorf("timestamp must be in ISO 8601 format (must match %v)", iso8601Regex)
 	}
 }
 
+// isISO8601 checks whether the timestamp matches iso8601Regex without the overhead of the regex
+func isISO8601(timestamp string) bool {
+	const layout = "0000-00-00T00:00:00"
+	if len(timestamp) < len(layout) {
+		return false
+	}
+	for i := 0; i < len(layout); i++ {
+		if layout[i] == '0' && !isDigit(timestamp[i]) || layout[i] != '0' && timestamp[i] != layout[i] {
+			return false
+		}
+	}
+	rest := timestamp[len(layout):]
+	if len(rest) > 0 && rest[0] == '.' {
+		digits := 1
+		for digits < len(rest) && isDigit(rest[digits]) {
+			digits++
+		}
+		if digits == 1 {
+			return false
+		}
+		rest = rest[digits:]
+	}
+	switch {
+	case rest == "" || rest == "Z":
+		return true
+	case len(rest) == 6:
+		return (rest[0] == '+' || rest[0] == '-') && isDigit(rest[1]) && isDigit(rest[2]) && rest[3] == ':' &&
+			isDigit(rest[4]) && isDigit(rest[5])
+	default:
+		return false
+	}
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// LogBatch is a batch of logs with a client generated id. The id must stay the same if the batch is sent again, so
+// that the API can detect duplicates of batches which were already ingested.
+type LogBatch struct {
+	Id   uuid.UUID `json:"id"`
+	Logs []*Log    `json:"logs"`
+	// Key is the idempotency key if it differs from the id, since logs were removed from the batch
+	Key uuid.UUID `json:"key"`
+
+	// payload caches the encoded logs in payloadFormat for retries. It is backed by buf, which is taken from
+	// bufferPool and returned by Release.
+	payload       []byte
+	payloadFormat string
+	buf           *bytes.Buffer
+}
+
+// maxPooledBufferSize limits the buffers which are kept in bufferPool, so a single huge batch does not pin its
+// buffer in memory
+const maxPooledBufferSize = 16 * 1024 * 1024
+
+var bufferPool = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+// Payload returns the logs encoded in the format, which is FormatArray if empty. They are encoded once into a pooled
+// buffer and cached, so retries of the batch send the same payload without encoding it again. The logs must not be
+// modified once the payload was created. The buffer is owned by the batch until Release is called.
+func (lb *LogBatch) Payload(format string) ([]byte, error) {
+	if lb.payload != nil && lb.payloadFormat == format {
+		return lb.payload, nil
+	}
+	if lb.buf == nil {
+		lb.buf = bufferPool.Get().(*bytes.Buffer)
+	}
+	lb.buf.Reset()
+	lb.payload = nil
+	enc := json.NewEncoder(lb.buf)
+	switch format {
+	case "", FormatArray:
+		if err := enc.Encode(lb.Logs); err != nil {
+			return nil, err
+		}
+	case FormatNdjson:
+		// Encode terminates each log with a newline
+		for _, log := range lb.Logs {
+			if err := enc.Encode(log); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown format %v", format)
+	}
+	lb.payload = lb.buf.Bytes()
+	lb.payloadFormat = format
+	return lb.payload, nil
+}
+
+// Release returns the buffer of the payload to the pool. It must be called once the batch was acknowledged by the API
+// or dropped, since the API could still be reading the payload before. A payload returned before must not be used
+// afterwards.
+func (lb *LogBatch) Release() {
+	if lb.buf == nil {
+		return
+	}
+	if lb.buf.Cap() <= maxPooledBufferSize {
+		bufferPool.Put(lb.buf)
+	}
+	lb.buf = nil
+	lb.payload = nil
+}
+
+func NewLogBatch(logs []*Log) *LogBatch {
+	return &LogBatch{Id: uuid.New(), Logs: logs}
+}
+
+// IdempotencyKey returns the key which identifies the batch with its current logs to the API
+func (lb *LogBatch) IdempotencyKey() uuid.UUID {
+	if lb.Key != uuid.Nil {
+		return lb.Key
+	}
+	return lb.Id
+}
+
+// Remove removes the logs at the indices from the batch and returns them in the order of the indices. The batch keeps
+// its id, but the idempotency key is derived from the previous key and the removed indices, since the API must not see
+// the same key with another payload. The key is the same whenever the same logs are removed, so a resent batch is
+// still recognized.
+func (lb *LogBatch) Remove(indices []int) []*Log {
+	removed := make([]*Log, 0, len(indices))
+	remove := make(map[int]bool, len(indices))
+	var removedIndices []string
+	for _, index := range indices {
+		if index >= 0 && index < len(lb.Logs) && !remove[index] {
+			remove[index] = true
+			removed = append(removed, lb.Logs[index])
+			removedIndices = append(removedIndices, strconv.Itoa(index))
+		}
+	}
+	if len(removed) == 0 {
+		return removed
+	}
+	kept := make([]*Log, 0, len(lb.Logs)-len(removed))
+	for i, log := range lb.Logs {
+		if !remove[i] {
+			kept = append(kept, log)
+		}
+	}
+	sort.Strings(removedIndices)
+	lb.Key = uuid.NewSHA1(lb.IdempotencyKey(), []byte(strings.Join(removedIndices, ",")))
+	lb.Logs = kept
+	// The buffer is kept, it is overwritten by the next payload
+	lb.payload = nil
+	return removed
+}
+
 // LogReceipt is returned upon sending a LogBatchRequest to the API.
 type LogReceipt struct {
 	ReceiptId uuid.UUID `json:"receiptId"`
 	LogsCount int       `json:"logsCount"`
 	BatchId   uuid.UUID `json:"batchId"`
 	Status    int       `json:"status"`
+	// Errors are the lines of a batch in FormatNdjson which were not ingested
+	Errors []LineError `json:"errors,omitempty"`
+}
+
+// LineError is the reason why the log in a line of a batch in FormatNdjson was not ingested. Lines start at 1.
+type LineError struct {
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+// RejectedLogsError is returned if the API rejected some logs of a batch. If Ingested is set, the other logs were
+// ingested. Otherwise, the whole batch was rejected with Err and the other logs must be sent again.
+type RejectedLogsError struct {
+	BatchId uuid.UUID
+	// Rejected maps the index of each rejected log in the batch to the reason
+	Rejected map[int]string
+	Ingested bool
+	Err      error
+}
+
+func (re RejectedLogsError) Error() string {
+	if re.Err == nil {
+		return fmt.Sprintf("%v logs of batch %v were rejected", len(re.Rejected), re.BatchId)
+	}
+	return fmt.Sprintf("%v; %v logs of batch %v were rejected", re.Err, len(re.Rejected), re.BatchId)
+}
+
+func (re RejectedLogsError) Unwrap() error {
+	return re.Err
 }
 
+// LogApi sends logs with the Authenticator of the BaseApi in the Format, which is FormatArray if empty
 type LogApi struct {
 	*BaseApi
-
-	User *User
+	Format string
 }
 
-func (la *LogApi) SendLogs(logs []*Log) (*LogReceipt, error) {
+func (la *LogApi) SendLogs(batch *LogBatch) (*LogReceipt, error) {
 	method := postLogBatchConf["method"]
-	// Make a copy to prevent side effects
-	urlLogin := la.Url
-	urlLogin.Path = postLogBatchConf["path"]
+	// Make a copy to prevent side effects, since logs can be sent concurrently
+	urlLogs := *la.Url
+	urlLogs.Path = postLogBatchConf["path"]
 
-	req, err := la.BuildRequestWithBasicAuth(method, urlLogin.String(), logs, la.User.Email, la.User.Password)
+	payload, err := batch.Payload(la.Format)
+	if err != nil {
+		return nil, la.sendLogBatchError(batch, err)
+	}
+	req, err := la.BuildAuthenticatedRequest(method, urlLogs.String(), json.RawMessage(payload))
 	if err != nil {
-		return nil, la.sendLogBatchError(logs, err)
+		return nil, la.sendLogBatchError(batch, err)
+	}
+	req.Header.Set(IdempotencyKeyHeader, batch.IdempotencyKey().String())
+	if la.Format == FormatNdjson {
+		req.Header.Set("Content-Type", NdjsonContentType)
 	}
 
 	resp, err := la.HttpClient.Do(req)
 	if err != nil {
-		return nil, la.sendLogBatchError(logs, err)
+		return nil, la.sendLogBatchError(batch, err)
 	}
 	defer la.closing(resp.Body)
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, la.GetUnexpectedStatusError(resp, http.StatusOK)
+		err := la.GetUnexpectedStatusError(resp, http.StatusOK)
+		if resp.StatusCode == http.StatusBadRequest {
+			return nil, rejectedFields(batch, err)
+		}
+		return nil, err
+	}
+	receipt := la.unmarshalLogReceipt(resp.Body)
+	if receipt != nil {
+		if rejected := rejectedLines(batch, receipt.Errors); len(rejected.Rejected) > 0 {
+			return receipt, rejected
+		}
+	}
+	return receipt, nil
+}
+
+// rejectedLines returns the logs of a batch in FormatNdjson which were not ingested
+func rejectedLines(batch *LogBatch, lineErrors []LineError) RejectedLogsError {
+	rejected := RejectedLogsError{BatchId: batch.Id, Rejected: make(map[int]string, len(lineErrors)), Ingested: true}
+	for _, lineErr := range lineErrors {
+		if lineErr.Line >= 1 && lineErr.Line <= len(batch.Logs) {
+			rejected.Rejected[lineErr.Line-1] = lineErr.Message
+		}
+	}
+	return rejected
+}
+
+// rejectedFields returns the invalid logs listed in the problem of a bad request. The StatusError is returned as is if
+// the problem lists no logs of the batch.
+func rejectedFields(batch *LogBatch, err error) error {
+	statusErr, ok := err.(StatusError)
+	if !ok || statusErr.Problem == nil {
+		return err
+	}
+	rejected := RejectedLogsError{BatchId: batch.Id, Rejected: make(map[int]string), Err: statusErr}
+	for _, fieldErr := range statusErr.Problem.Errors {
+		if fieldErr.Index < 0 || fieldErr.Index >= len(batch.Logs) {
+			continue
+		}
+		// A log can have several invalid fields
+		if reason, exists := rejected.Rejected[fieldErr.Index]; exists {
+			rejected.Rejected[fieldErr.Index] = reason + "; " + fieldErr.reason()
+		} else {
+			rejected.Rejected[fieldErr.Index] = fieldErr.reason()
+		}
+	}
+	if len(rejected.Rejected) == 0 {
+		return err
 	}
-	return la.unmarshalLogReceipt(resp.Body), nil
+	return rejected
 }
 
 func (la *LogApi) unmarshalLogReceipt(body io.ReadCloser) *LogReceipt {
@@ -102,6 +354,8 @@ func (la *LogApi) unmarshalLogReceipt(body io.ReadCloser) *LogReceipt {
 	return &logReceipt
 }
 
-func (la *LogApi) sendLogBatchError(logs []*Log, err error) error {
-	return fmt.Errorf("%w; log sending with request %v failed", err, logs)
+// sendLogBatchError only reports the size of the batch. The logs itself are not part of the error since they can
+// contain sensitive data.
+func (la *LogApi) sendLogBatchError(batch *LogBatch, err error) error {
+	return fmt.Errorf("%w; sending batch %v of %v logs failed", err, batch.Id, len(batch.Logs))
 }
@@ -1,12 +1,17 @@
 package api
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"reflect"
+	"regexp"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -265,10 +270,9 @@ func TestLogApi_SendLogBatch(t *testing.T) {
 
 	type fields struct {
 		BaseApi *BaseApi
-		User    *User
 	}
 	type args struct {
-		logs []*Log
+		batch *LogBatch
 	}
 	jsonLogReceiptValid := []byte(fmt.Sprintf(
 		`{"receiptId":"%v","logsCount":1,"batchId":"%v","status":0}`, idUUID, idUUID))
@@ -323,36 +327,36 @@ func TestLogApi_SendLogBatch(t *testing.T) {
 	}{
 		{
 			name:    "pass valid receipt",
-			fields:  fields{User: &User{}, BaseApi: baseApiPassValidReceipt},
-			args:    args{logs: []*Log{&log}},
+			fields:  fields{BaseApi: baseApiPassValidReceipt},
+			args:    args{batch: &LogBatch{Id: idUUID, Logs: []*Log{&log}}},
 			want:    logReceipt,
 			wantErr: false,
 		},
 		{
 			name:    "pass invalid receipt",
-			fields:  fields{User: &User{}, BaseApi: baseApiPassInvalidReceipt},
-			args:    args{logs: []*Log{&log}},
+			fields:  fields{BaseApi: baseApiPassInvalidReceipt},
+			args:    args{batch: &LogBatch{Id: idUUID, Logs: []*Log{&log}}},
 			want:    nil,
 			wantErr: false,
 		},
 		{
 			name:    "fail",
-			fields:  fields{User: &User{}, BaseApi: baseApiFail},
-			args:    args{logs: []*Log{&log}},
+			fields:  fields{BaseApi: baseApiFail},
+			args:    args{batch: &LogBatch{Id: idUUID, Logs: []*Log{&log}}},
 			want:    nil,
 			wantErr: true,
 		},
 		{
 			name:    "fail retry1",
-			fields:  fields{User: &User{}, BaseApi: baseApiFailRetry1},
-			args:    args{logs: []*Log{&log}},
+			fields:  fields{BaseApi: baseApiFailRetry1},
+			args:    args{batch: &LogBatch{Id: idUUID, Logs: []*Log{&log}}},
 			want:    nil,
 			wantErr: true,
 		},
 		{
 			name:    "fail retry2",
-			fields:  fields{User: &User{}, BaseApi: baseApiFailRetry2},
-			args:    args{logs: []*Log{&log}},
+			fields:  fields{BaseApi: baseApiFailRetry2},
+			args:    args{batch: &LogBatch{Id: idUUID, Logs: []*Log{&log}}},
 			want:    nil,
 			wantErr: true,
 		},
@@ -361,9 +365,8 @@ func TestLogApi_SendLogBatch(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			la := &LogApi{
 				BaseApi: tt.fields.BaseApi,
-				User:    tt.fields.User,
 			}
-			got, err := la.SendLogs(tt.args.logs)
+			got, err := la.SendLogs(tt.args.batch)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("SendLogs() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -374,3 +377,332 @@ func TestLogApi_SendLogBatch(t *testing.T) {
 		})
 	}
 }
+
+func TestLogApi_sendLogBatchError(t *testing.T) {
+	secret := "user password is foundation_rulez"
+	logs := []*Log{{Timestamp: "2022-04-04T09:00:35+00:00", Message: secret, Level: "INFO"}}
+	la := &LogApi{}
+	err := la.sendLogBatchError(&LogBatch{Logs: logs}, fmt.Errorf("connection refused"))
+	if strings.Contains(err.Error(), secret) {
+		t.Errorf("sendLogBatchError() = %v contains the log message", err)
+	}
+}
+
+func TestLogApi_SendLogs_idempotencyKey(t *testing.T) {
+	batch := NewLogBatch([]*Log{{Timestamp: "2022-04-04T09:00:35+00:00", Message: "Test message", Level: "INFO"}})
+	var gotKeys []string
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		gotKeys = append(gotKeys, req.Header.Get(IdempotencyKeyHeader))
+		res.WriteHeader(http.StatusOK)
+	}))
+	defer testServer.Close()
+	urlTestServer, _ := url.Parse(testServer.URL)
+	la := &LogApi{BaseApi: &BaseApi{HttpClient: http.DefaultClient, Url: urlTestServer}}
+
+	// The key must not change when the batch is sent again
+	for i := 0; i < 2; i++ {
+		if _, err := la.SendLogs(batch); err != nil {
+			t.Fatalf("SendLogs() error = %v", err)
+		}
+	}
+	want := []string{batch.Id.String(), batch.Id.String()}
+	if !reflect.DeepEqual(gotKeys, want) {
+		t.Errorf("SendLogs() sent idempotency keys %v, want %v", gotKeys, want)
+	}
+}
+
+func TestLogApi_SendLogs_concurrent(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(http.StatusOK)
+	}))
+	defer testServer.Close()
+	urlTestServer, _ := url.Parse(testServer.URL + "/base")
+	la := &LogApi{BaseApi: &BaseApi{HttpClient: http.DefaultClient, Url: urlTestServer}}
+
+	// The client sends flushed logs while the pipeline publishes
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			batch := NewLogBatch([]*Log{{Timestamp: "2022-04-04T09:00:35+00:00", Message: "Test message", Level: "INFO"}})
+			if _, err := la.SendLogs(batch); err != nil {
+				t.Errorf("SendLogs() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if la.Url.Path != "/base" {
+		t.Errorf("SendLogs() changed the url of the api to %v", la.Url)
+	}
+}
+
+func BenchmarkLog_ValidateLog(b *testing.B) {
+	log := &Log{
+		Timestamp: "2022-04-04T09:00:35.123456+02:00",
+		Message:   "Test message",
+		Level:     "WARNING",
+		Tags:      map[string]string{"default": "default"},
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := log.ValidateLog(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func Test_isISO8601(t *testing.T) {
+	expr := regexp.MustCompile(iso8601Regex)
+	timestamps := []string{
+		"2022-04-04T09:00:35",
+		"2022-04-04T09:00:35Z",
+		"2022-04-04T09:00:35.1Z",
+		"2022-04-04T09:00:35.123456789+02:00",
+		"2022-04-04T09:00:35-05:30",
+		"2022-04-04T09:00:35.Z",
+		"2022-04-04T09:00:35+0200",
+		"2022-04-04 09:00:35",
+		"2022-4-04T09:00:35",
+		"2022-04-04T09:00:35ZZ",
+		"2022-04-04T09:00",
+		"",
+	}
+	for _, timestamp := range timestamps {
+		t.Run(timestamp, func(t *testing.T) {
+			if got, want := isISO8601(timestamp), expr.MatchString(timestamp); got != want {
+				t.Errorf("isISO8601() = %v, want %v as %v", got, want, iso8601Regex)
+			}
+		})
+	}
+}
+
+func testLogs(n int) []*Log {
+	logs := make([]*Log, n)
+	for i := range logs {
+		logs[i] = &Log{
+			Timestamp: "2022-04-04T09:00:35+00:00",
+			Message:   fmt.Sprintf("GET /api/v1/orders/%v 200", i),
+			Level:     "INFO",
+			Tags:      map[string]string{"host": "node-1", "namespace": "shop"},
+		}
+	}
+	return logs
+}
+
+func TestLogBatch_Payload(t *testing.T) {
+	batch := NewLogBatch(testLogs(3))
+	payload, err := batch.Payload(FormatArray)
+	if err != nil {
+		t.Fatalf("Payload() error = %v", err)
+	}
+	var decoded []*Log
+	if err := json.Unmarshal(payload, &decoded); err != nil {
+		t.Fatalf("Payload() is not a json array of logs: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, batch.Logs) {
+		t.Errorf("Payload() = %s, want %v", payload, batch.Logs)
+	}
+	cached, _ := batch.Payload(FormatArray)
+	if &cached[0] != &payload[0] {
+		t.Errorf("Payload() encoded the batch again instead of using the cached payload")
+	}
+}
+
+func TestLogBatch_Payload_ndjson(t *testing.T) {
+	batch := NewLogBatch(testLogs(3))
+	payload, err := batch.Payload(FormatNdjson)
+	if err != nil {
+		t.Fatalf("Payload() error = %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(payload), "\n"), "\n")
+	if len(lines) != len(batch.Logs) {
+		t.Fatalf("Payload() = %s, want one line per log", payload)
+	}
+	for i, line := range lines {
+		var decoded *Log
+		if err := json.Unmarshal([]byte(line), &decoded); err != nil {
+			t.Fatalf("line %v is not a json log: %v", i, err)
+		}
+		if !reflect.DeepEqual(decoded, batch.Logs[i]) {
+			t.Errorf("line %v = %v, want %v", i, line, batch.Logs[i])
+		}
+	}
+
+	// The cached payload of another format must not be reused
+	array, _ := batch.Payload(FormatArray)
+	if !strings.HasPrefix(string(array), "[") {
+		t.Errorf("Payload(%v) = %s, want a json array", FormatArray, array)
+	}
+	if _, err := batch.Payload("xml"); err == nil {
+		t.Errorf("Payload() of an unknown format did not return an error")
+	}
+}
+
+func TestLogApi_SendLogs_ndjson(t *testing.T) {
+	batch := NewLogBatch(testLogs(3))
+	var gotContentType string
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		gotContentType = req.Header.Get("Content-Type")
+		res.WriteHeader(http.StatusOK)
+		_, _ = res.Write([]byte(`{"logsCount":1,"errors":[{"line":1,"message":"level is required"},` +
+			`{"line":3,"message":"message is required"},{"line":7,"message":"no such line"}]}`))
+	}))
+	defer testServer.Close()
+	urlTestServer, _ := url.Parse(testServer.URL)
+	la := &LogApi{BaseApi: &BaseApi{HttpClient: http.DefaultClient, Url: urlTestServer}, Format: FormatNdjson}
+
+	receipt, err := la.SendLogs(batch)
+	var rejectedErr RejectedLogsError
+	if !errors.As(err, &rejectedErr) {
+		t.Fatalf("SendLogs() error = %v, want a RejectedLogsError", err)
+	}
+	if gotContentType != NdjsonContentType {
+		t.Errorf("SendLogs() sent content type %v, want %v", gotContentType, NdjsonContentType)
+	}
+	if receipt == nil || receipt.LogsCount != 1 {
+		t.Errorf("SendLogs() receipt = %v, want 1 ingested log", receipt)
+	}
+	if rejectedErr.BatchId != batch.Id {
+		t.Errorf("SendLogs() rejected batch %v, want %v", rejectedErr.BatchId, batch.Id)
+	}
+	wantRejected := map[int]string{0: "level is required", 2: "message is required"}
+	if !reflect.DeepEqual(rejectedErr.Rejected, wantRejected) {
+		t.Errorf("SendLogs() rejected = %v, want %v", rejectedErr.Rejected, wantRejected)
+	}
+}
+
+func TestLogApi_SendLogs_problem(t *testing.T) {
+	tests := []struct {
+		name         string
+		contentType  string
+		body         string
+		wantRejected map[int]string
+	}{
+		{
+			name:        "pass problem with invalid logs",
+			contentType: "application/problem+json; charset=utf-8",
+			body: `{"title":"invalid logs","status":400,"errors":[{"index":0,"field":"level","detail":"is required"},` +
+				`{"index":2,"detail":"is too large"},{"index":0,"field":"message","detail":"is required"},` +
+				`{"index":5,"detail":"no such log"}]}`,
+			wantRejected: map[int]string{0: "level: is required; message: is required", 2: "is too large"},
+		},
+		{
+			name:        "fail problem without invalid logs",
+			contentType: ProblemContentType,
+			body:        `{"title":"invalid batch","status":400}`,
+		},
+		{
+			name:        "fail plain json",
+			contentType: "application/json",
+			body:        `{"errors":[{"index":0,"detail":"is invalid"}]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			batch := NewLogBatch(testLogs(3))
+			testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+				res.Header().Set("Content-Type", tt.contentType)
+				res.WriteHeader(http.StatusBadRequest)
+				_, _ = res.Write([]byte(tt.body))
+			}))
+			defer testServer.Close()
+			urlTestServer, _ := url.Parse(testServer.URL)
+			la := &LogApi{BaseApi: &BaseApi{HttpClient: http.DefaultClient, Url: urlTestServer}}
+
+			_, err := la.SendLogs(batch)
+			var statusErr StatusError
+			if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusBadRequest {
+				t.Fatalf("SendLogs() error = %v, want a StatusError with status 400", err)
+			}
+			var rejectedErr RejectedLogsError
+			isRejected := errors.As(err, &rejectedErr)
+			if isRejected != (tt.wantRejected != nil) {
+				t.Fatalf("SendLogs() error = %v, want rejected logs %v", err, tt.wantRejected)
+			}
+			if !isRejected {
+				return
+			}
+			if rejectedErr.Ingested {
+				t.Errorf("SendLogs() reported the logs of a bad request as ingested")
+			}
+			if !reflect.DeepEqual(rejectedErr.Rejected, tt.wantRejected) {
+				t.Errorf("SendLogs() rejected = %v, want %v", rejectedErr.Rejected, tt.wantRejected)
+			}
+		})
+	}
+}
+
+func TestLogBatch_Remove(t *testing.T) {
+	logs := testLogs(4)
+	batch := NewLogBatch(logs)
+	id := batch.Id
+	if _, err := batch.Payload(FormatArray); err != nil {
+		t.Fatalf("Payload() error = %v", err)
+	}
+
+	removed := batch.Remove([]int{1, 3, 3, 7})
+	if !reflect.DeepEqual(removed, []*Log{logs[1], logs[3]}) {
+		t.Errorf("Remove() = %v, want the logs 1 and 3", removed)
+	}
+	if !reflect.DeepEqual(batch.Logs, []*Log{logs[0], logs[2]}) {
+		t.Errorf("Remove() kept %v, want the logs 0 and 2", batch.Logs)
+	}
+	if batch.Id != id {
+		t.Errorf("Remove() changed the batch id")
+	}
+	if batch.IdempotencyKey() == id {
+		t.Errorf("Remove() kept the idempotency key of the batch with other logs")
+	}
+	same := &LogBatch{Id: id, Logs: logs}
+	same.Remove([]int{3, 1})
+	if same.IdempotencyKey() != batch.IdempotencyKey() {
+		t.Errorf("Remove() derived the key %v, want %v for the same removed logs", same.IdempotencyKey(),
+			batch.IdempotencyKey())
+	}
+	payload, _ := batch.Payload(FormatArray)
+	var decoded []*Log
+	if err := json.Unmarshal(payload, &decoded); err != nil || len(decoded) != 2 {
+		t.Errorf("Payload() = %s after Remove(), want the 2 kept logs", payload)
+	}
+}
+
+func TestLogBatch_Release(t *testing.T) {
+	batch := NewLogBatch(testLogs(2))
+	payload, _ := batch.Payload(FormatArray)
+	want := string(payload)
+	batch.Release()
+	batch.Release()
+
+	// The payload is encoded again after the buffer was released
+	payload, err := batch.Payload(FormatArray)
+	if err != nil {
+		t.Fatalf("Payload() error = %v", err)
+	}
+	if string(payload) != want {
+		t.Errorf("Payload() = %s after Release(), want %s", payload, want)
+	}
+}
+
+// BenchmarkLogBatch_Payload compares the pooled payload with marshalling the logs into a new slice for every batch
+func BenchmarkLogBatch_Payload(b *testing.B) {
+	logs := testLogs(100)
+	b.Run("marshal", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := json.Marshal(logs); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			batch := &LogBatch{Logs: logs}
+			if _, err := batch.Payload(FormatArray); err != nil {
+				b.Fatal(err)
+			}
+			batch.Release()
+		}
+	})
+}
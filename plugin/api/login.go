@@ -51,7 +51,7 @@ type LoginApi struct {
 func (la *LoginApi) Login(loginReq LoginRequest) (*LoginResponse, error) {
 	method := loginConf["method"]
 	// Make a copy to prevent side effects
-	urlLogin := la.Url
+	urlLogin := *la.Url
 	urlLogin.Path = loginConf["path"]
 
 	req, err := la.BuildRequest(method, urlLogin.String(), loginReq)
@@ -82,12 +82,13 @@ func (la *LoginApi) unmarshal(body io.ReadCloser) (*LoginResponse, error) {
 		return nil, err
 	}
 
+	// The response body is not part of the errors since it contains the token
 	var loginResp LoginResponse
 	if err := json.Unmarshal(bodyBytes, &loginResp); err != nil {
-		return nil, fmt.Errorf("%w; error while unmarshalling the Login object %v", err, string(bodyBytes))
+		return nil, fmt.Errorf("%w; error while unmarshalling the Login object", err)
 	}
 
-	errMsg := fmt.Sprintf("unmarshalling login response from %v failed", bodyBytes)
+	errMsg := "unmarshalling login response failed"
 	if loginResp.Token == nil {
 		return nil, fmt.Errorf("%v; token is nil", errMsg)
 	}
@@ -4,8 +4,8 @@ type LogSender struct {
 	LogApi *LogApi
 }
 
-func (as LogSender) Send(logs []*Log) error {
-	if _, err := as.LogApi.SendLogs(logs); err != nil {
+func (as LogSender) Send(batch *LogBatch) error {
+	if _, err := as.LogApi.SendLogs(batch); err != nil {
 		return err
 	}
 	return nil
@@ -27,7 +27,7 @@ func TestLogSender_Send(t *testing.T) {
 		LogApi *LogApi
 	}
 	type args struct {
-		logs []*Log
+		batch *LogBatch
 	}
 	var tests []struct {
 		name    string
@@ -40,7 +40,7 @@ func TestLogSender_Send(t *testing.T) {
 			as := LogSender{
 				LogApi: tt.fields.LogApi,
 			}
-			if err := as.Send(tt.args.logs); (err != nil) != tt.wantErr {
+			if err := as.Send(tt.args.batch); (err != nil) != tt.wantErr {
 				t.Errorf("Send() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
@@ -0,0 +1,114 @@
+package plugin
+
+import (
+	"crypto/tls"
+	"fmt"
+	"github.com/elastic/beats/v7/libbeat/common/transport"
+	"github.com/elastic/beats/v7/libbeat/common/transport/tlscommon"
+	"github.com/elastic/beats/v7/libbeat/logp"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// certificateReloader keeps the client certificate of a TLS config up to date with the certificate and key files on
+// disk. The files are checked for modifications before a new connection is established, so rotated certificates
+// are presented on the next TLS handshake without restarting filebeat.
+type certificateReloader struct {
+	tlsConfig  *tlscommon.TLSConfig
+	certConfig tlscommon.CertificateConfig
+	logger     *logp.Logger
+
+	mutex    sync.Mutex
+	current  *tlscommon.TLSConfig
+	modTimes []time.Time
+}
+
+func newCertificateReloader(tlsConfig *tlscommon.TLSConfig, certConfig tlscommon.CertificateConfig,
+	logger *logp.Logger) (*certificateReloader, error) {
+	if tlsConfig == nil {
+		return nil, fmt.Errorf("tls must be enabled to use a client certificate")
+	}
+	if certConfig.Certificate == "" || certConfig.Key == "" {
+		return nil, fmt.Errorf("tls.certificate and tls.key must be set to use a client certificate")
+	}
+	cr := &certificateReloader{
+		tlsConfig:  tlsConfig,
+		certConfig: certConfig,
+		logger:     logger,
+		current:    tlsConfig,
+	}
+	if _, err := cr.currentTLSConfig(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// currentTLSConfig returns the TLS config with the latest client certificate. The certificate is only loaded again
+// if the modification time of the certificate or key file has changed. If the reload fails, the previously loaded
+// certificate is kept.
+func (cr *certificateReloader) currentTLSConfig() (*tlscommon.TLSConfig, error) {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+
+	modTimes := cr.fileModTimes()
+	if cr.modTimes != nil && equalTimes(modTimes, cr.modTimes) {
+		return cr.current, nil
+	}
+
+	certificate, err := tlscommon.LoadCertificate(&cr.certConfig)
+	if err != nil {
+		if cr.modTimes == nil {
+			return nil, fmt.Errorf("%w; failed to load client certificate %v", err, cr.certConfig.Certificate)
+		}
+		cr.logger.Errorf("failed to reload client certificate %v. keeping the previous one, Error: %v",
+			cr.certConfig.Certificate, err)
+		return cr.current, nil
+	}
+	if cr.modTimes != nil {
+		cr.logger.Infof("reloaded client certificate %v", cr.certConfig.Certificate)
+	}
+
+	// The TLS config is copied, so that connections which are already established keep their config unchanged
+	reloaded := *cr.tlsConfig
+	reloaded.Certificates = []tls.Certificate{*certificate}
+	cr.current = &reloaded
+	cr.modTimes = modTimes
+	return cr.current, nil
+}
+
+// fileModTimes returns the modification times of the certificate and key. Inline PEM or files that can not be
+// accessed result in a zero time, so that they are not reloaded.
+func (cr *certificateReloader) fileModTimes() []time.Time {
+	modTimes := make([]time.Time, 2)
+	for i, path := range []string{cr.certConfig.Certificate, cr.certConfig.Key} {
+		if info, err := os.Stat(path); err == nil {
+			modTimes[i] = info.ModTime()
+		}
+	}
+	return modTimes
+}
+
+func equalTimes(a []time.Time, b []time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// TLSDialer returns a dialer which establishes every connection with the latest client certificate.
+func (cr *certificateReloader) TLSDialer(forward transport.Dialer, timeout time.Duration) transport.Dialer {
+	return transport.DialerFunc(func(network, address string) (net.Conn, error) {
+		tlsConfig, err := cr.currentTLSConfig()
+		if err != nil {
+			return nil, err
+		}
+		return transport.TLSDialer(forward, tlsConfig, timeout).Dial(network, address)
+	})
+}
@@ -0,0 +1,335 @@
+package plugin
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/aiops/logsight-filebeat/plugin/api"
+	"github.com/aiops/logsight-filebeat/plugin/api/apitest"
+	"github.com/elastic/beats/v7/libbeat/common/transport/tlscommon"
+	"github.com/elastic/beats/v7/libbeat/logp"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testCA is a local certificate authority which issues server and client certificates for the tests
+type testCA struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	serial  int64
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "logsight test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		serial:  1,
+	}
+}
+
+// issue returns the PEM encoded certificate and key for the common name
+func (ca *testCA) issue(t *testing.T, commonName string, server bool) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca.serial++
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(ca.serial),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if server {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		template.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+func writeFile(t *testing.T, path string, content []byte, modTime time.Time) {
+	if err := ioutil.WriteFile(path, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	// The modification time is set explicitly since the resolution of the file system can be too coarse
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newMTLSServer starts a stand-in server which requires client certificates issued by the ca. The common name of
+// the last client certificate is returned by the commonName function.
+func newMTLSServer(t *testing.T, ca *testCA) (*apitest.Server, func() string) {
+	serverCert, serverKey := ca.issue(t, "logsight", true)
+	serverKeyPair, err := tls.X509KeyPair(serverCert, serverKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+
+	var mutex sync.Mutex
+	var lastCommonName string
+	server := apitest.NewUnstartedServer()
+	handler := server.Config.Handler
+	server.Config.Handler = http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		mutex.Lock()
+		lastCommonName = req.TLS.PeerCertificates[0].Subject.CommonName
+		mutex.Unlock()
+		handler.ServeHTTP(res, req)
+	})
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverKeyPair},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	}
+	server.StartTLS()
+	return server, func() string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return lastCommonName
+	}
+}
+
+func newCertificateClient(t *testing.T, server *apitest.Server, tlsCommonConfig *tlscommon.Config, mode string) (*Client, error) {
+	tlsConfig, err := tlscommon.LoadTLSConfig(tlsCommonConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := defaultLogsightConfig
+	config.Url = server.URL
+	config.TLS = tlsCommonConfig
+	config.Auth = authConfig{Mode: mode}
+	config.Timeout = 5
+	if err := config.resolveCredentials(); err != nil {
+		return nil, err
+	}
+	hostURL, _ := url.Parse(server.URL)
+	return NewClient(config, hostURL, nil, tlsConfig, nil, logp.NewLogger("test"))
+}
+
+func TestClient_certificateAuth(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	server, commonName := newMTLSServer(t, ca)
+	defer server.Close()
+
+	caFile := filepath.Join(dir, "ca.pem")
+	certFile := filepath.Join(dir, "client.pem")
+	keyFile := filepath.Join(dir, "client.key")
+	now := time.Now()
+	writeFile(t, caFile, ca.certPEM, now)
+	cert, key := ca.issue(t, "client-1", false)
+	writeFile(t, certFile, cert, now)
+	writeFile(t, keyFile, key, now)
+
+	tlsCommonConfig := &tlscommon.Config{
+		CAs:         []string{caFile},
+		Certificate: tlscommon.CertificateConfig{Certificate: certFile, Key: keyFile},
+	}
+	client, err := newCertificateClient(t, server, tlsCommonConfig, AuthModeCertificate)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	defer func() { _ = client.Close() }()
+
+	logs := []*api.Log{{Timestamp: "2022-04-04T09:00:35+00:00", Message: "test", Level: "INFO"}}
+	send := func(wantCommonName string) {
+		t.Helper()
+		// Close idle connections to force a new TLS handshake
+		client.logSender.Close()
+		if err := client.publish(api.NewLogBatch(logs)); err != nil {
+			t.Fatalf("publish() error = %v", err)
+		}
+		if got := commonName(); got != wantCommonName {
+			t.Errorf("publish() presented certificate %v, want %v", got, wantCommonName)
+		}
+	}
+
+	send("client-1")
+
+	// rotate the certificate on disk
+	cert, key = ca.issue(t, "client-2", false)
+	writeFile(t, certFile, cert, now.Add(time.Minute))
+	writeFile(t, keyFile, key, now.Add(time.Minute))
+	send("client-2")
+
+	// an invalid certificate on disk keeps the previous one
+	writeFile(t, certFile, []byte("invalid"), now.Add(2*time.Minute))
+	send("client-2")
+
+	if got := len(server.Logs()); got != 3 {
+		t.Errorf("server received %v logs, want 3", got)
+	}
+}
+
+func TestClient_certificateAuthFail(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	otherCA := newTestCA(t)
+	server, _ := newMTLSServer(t, ca)
+	defer server.Close()
+
+	caFile := filepath.Join(dir, "ca.pem")
+	certFile := filepath.Join(dir, "client.pem")
+	keyFile := filepath.Join(dir, "client.key")
+	now := time.Now()
+	writeFile(t, caFile, ca.certPEM, now)
+	cert, key := otherCA.issue(t, "intruder", false)
+	writeFile(t, certFile, cert, now)
+	writeFile(t, keyFile, key, now)
+
+	logs := api.NewLogBatch([]*api.Log{{Timestamp: "2022-04-04T09:00:35+00:00", Message: "test", Level: "INFO"}})
+
+	tests := []struct {
+		name          string
+		config        *tlscommon.Config
+		mode          string
+		wantClientErr bool
+	}{
+		{
+			name: "fail certificate of unknown ca",
+			config: &tlscommon.Config{
+				CAs:         []string{caFile},
+				Certificate: tlscommon.CertificateConfig{Certificate: certFile, Key: keyFile},
+			},
+			mode:          AuthModeCertificate,
+			wantClientErr: false,
+		},
+		{
+			name:          "fail no certificate",
+			config:        &tlscommon.Config{CAs: []string{caFile}},
+			mode:          AuthModeNone,
+			wantClientErr: false,
+		},
+		{
+			name:          "fail certificate mode without certificate",
+			config:        &tlscommon.Config{CAs: []string{caFile}},
+			mode:          AuthModeCertificate,
+			wantClientErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := newCertificateClient(t, server, tt.config, tt.mode)
+			if (err != nil) != tt.wantClientErr {
+				t.Errorf("NewClient() error = %v, wantErr %v", err, tt.wantClientErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+			defer func() { _ = client.Close() }()
+			if err := client.publish(logs); err == nil {
+				t.Errorf("publish() error = nil, want handshake error")
+			}
+		})
+	}
+}
+
+func Test_newCertificateReloader(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	certFile := filepath.Join(dir, "client.pem")
+	keyFile := filepath.Join(dir, "client.key")
+	cert, key := ca.issue(t, "client", false)
+	writeFile(t, certFile, cert, time.Now())
+	writeFile(t, keyFile, key, time.Now())
+
+	tests := []struct {
+		name       string
+		tlsConfig  *tlscommon.TLSConfig
+		certConfig tlscommon.CertificateConfig
+		wantErr    bool
+	}{
+		{
+			name:       "pass files",
+			tlsConfig:  &tlscommon.TLSConfig{},
+			certConfig: tlscommon.CertificateConfig{Certificate: certFile, Key: keyFile},
+			wantErr:    false,
+		},
+		{
+			name:       "pass inline pem",
+			tlsConfig:  &tlscommon.TLSConfig{},
+			certConfig: tlscommon.CertificateConfig{Certificate: string(cert), Key: string(key)},
+			wantErr:    false,
+		},
+		{
+			name:       "fail tls disabled",
+			tlsConfig:  nil,
+			certConfig: tlscommon.CertificateConfig{Certificate: certFile, Key: keyFile},
+			wantErr:    true,
+		},
+		{
+			name:       "fail missing key",
+			tlsConfig:  &tlscommon.TLSConfig{},
+			certConfig: tlscommon.CertificateConfig{Certificate: certFile},
+			wantErr:    true,
+		},
+		{
+			name:       "fail missing file",
+			tlsConfig:  &tlscommon.TLSConfig{},
+			certConfig: tlscommon.CertificateConfig{Certificate: filepath.Join(dir, "missing"), Key: keyFile},
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newCertificateReloader(tt.tlsConfig, tt.certConfig, logp.NewLogger("test"))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("newCertificateReloader() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			tlsConfig, _ := got.currentTLSConfig()
+			if len(tlsConfig.Certificates) != 1 {
+				t.Errorf("currentTLSConfig() got %v certificates, want 1", len(tlsConfig.Certificates))
+			}
+		})
+	}
+}
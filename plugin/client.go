@@ -2,30 +2,187 @@ package plugin
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/aiops/logsight-filebeat/plugin/api"
 	"github.com/aiops/logsight-filebeat/plugin/mapper"
+	"github.com/aiops/logsight-filebeat/plugin/processor"
+	"github.com/aiops/logsight-filebeat/plugin/spool"
+	"github.com/elastic/beats/v7/libbeat/common"
 	"github.com/elastic/beats/v7/libbeat/common/transport"
 	"github.com/elastic/beats/v7/libbeat/common/transport/tlscommon"
 	"github.com/elastic/beats/v7/libbeat/logp"
 	"github.com/elastic/beats/v7/libbeat/outputs"
 	"github.com/elastic/beats/v7/libbeat/publisher"
+	"github.com/google/uuid"
 	"net/http"
 	"net/url"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// batchIdMetaKey is the key of the batch id in the metadata of published events
+const batchIdMetaKey = "logsight_batch_id"
+
+// flushInterval is the interval in which the logs held back by the processors are checked and sent when due
+const flushInterval = 10 * time.Second
+
+// maxFailedBatches limits the failed batches which are kept until they are retried
+const maxFailedBatches = 64
+
+// maxUnflushedLogs limits the logs flushed by the processors which are kept until they could be sent
+const maxUnflushedLogs = 1000
+
 // Client struct
 type Client struct {
-	logMapper *mapper.LogMapper
-	logSender api.LogSender
-	observer  *outputs.Observer
-	logger    *logp.Logger
+	logMapper  *mapper.LogMapper
+	processors processor.Pipeline
+	logSender  api.LogSender
+	observer   *outputs.Observer
+	logger     *logp.Logger
+
+	// failed are the batches which could not be published yet
+	failed failedBatches
+
+	// deadLetter is nil if no dead letter path is configured
+	deadLetter *deadLetterFile
+
+	// spool is nil if no spool path is configured
+	spool              *spool.Spool
+	spoolMutex         sync.Mutex
+	spoolRetryInterval time.Duration
+	spoolDone          chan struct{}
+
+	flushDone chan struct{}
+	// loops are the goroutines which drain the spool and flush the processors
+	loops sync.WaitGroup
+
+	// unflushed are the batches of logs flushed by the processors which could not be sent yet
+	unflushed  []*api.LogBatch
+	flushMutex sync.Mutex
+	// publishFailed is set if the last publish failed. The pipeline closes the client after a failed publish and
+	// connects it again, so the client is not shut down.
+	publishFailed int32
+
+	// closedCounts are the counters of the processors which were added to the closed counters of processorMetrics
+	closedCounts map[string]map[string]uint64
 }
 
 // NewClient instantiates a client.
 func NewClient(config logsightConfig, hostURL *url.URL, proxyURL *url.URL, tlsConfig *tlscommon.TLSConfig, observer outputs.Observer, logger *logp.Logger) (*Client, error) {
+	logMapper, err := newLogMapper(config)
+	if err != nil {
+		return nil, err
+	}
+	processors, err := newProcessors(config, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	httpClient, err := newHttpClient(config, proxyURL, tlsConfig, observer, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	baseApi := &api.BaseApi{HttpClient: httpClient, Url: hostURL}
+	authenticator, err := newAuthenticator(config, baseApi)
+	if err != nil {
+		return nil, err
+	}
+	logger.Infof("using %v", authenticator)
+	baseApi.Authenticator = authenticator
+	logApi := &api.LogApi{BaseApi: baseApi, Format: config.ApiFormat}
+	logSender := api.LogSender{
+		LogApi: logApi,
+	}
+
+	client := &Client{
+		logMapper:  logMapper,
+		processors: processors,
+		logSender:  logSender,
+		observer:   &observer,
+		logger:     logger,
+	}
+	if config.DeadLetter.Path != "" {
+		client.deadLetter, err = openDeadLetterFile(config.DeadLetter.Path)
+		if err != nil {
+			return nil, err
+		}
+		logger.Infof("writing logs rejected by logsight to %v", config.DeadLetter.Path)
+	}
+	if config.Spool.Path != "" {
+		client.spool, err = spool.Open(config.Spool.Path, int64(config.Spool.MaxSize))
+		if err != nil {
+			return nil, err
+		}
+		client.spoolRetryInterval = config.Spool.RetryInterval
+		logger.Infof("spooling to %v while logsight is unreachable. %v batches are already spooled",
+			config.Spool.Path, client.spool.Len())
+	}
+	processorMetrics.add(client)
+
+	return client, nil
+}
+
+// newLogMapper creates the log mapper of the top level mapping config together with the conditional mapping rules
+// and the hints.
+func newLogMapper(config logsightConfig) (*mapper.LogMapper, error) {
+	logMapper, err := config.Mapping.toLogMapper()
+	if err != nil {
+		return nil, err
+	}
+	for i, ruleConf := range config.Mappings {
+		rule, err := ruleConf.toMappingRule()
+		if err != nil {
+			return nil, fmt.Errorf("%w; invalid mapping rule %v", err, i)
+		}
+		logMapper.Rules = append(logMapper.Rules, rule)
+	}
+	logMapper.Hints = config.Hints.toAnnotationHints()
+	logMapper.Workers = config.MappingWorkers
+	return logMapper, nil
+}
+
+// newProcessors creates the processors which are applied to the mapped logs
+func newProcessors(config logsightConfig, logger *logp.Logger) (processor.Pipeline, error) {
+	var processors processor.Pipeline
+	filter, err := config.Filter.toFilter()
+	if err != nil {
+		return nil, err
+	}
+	if filter != nil {
+		processors = append(processors, filter)
+	}
+	if len(config.Redaction) > 0 {
+		redactor := &processor.Redactor{}
+		for i, ruleConf := range config.Redaction {
+			rule, err := ruleConf.toRedactionRule(config.PseudonymizationKey)
+			if err != nil {
+				return nil, fmt.Errorf("%w; invalid redaction rule %v", err, i)
+			}
+			if rule.Action == processor.ActionHash {
+				logger.Warnf("redaction rule %v uses the hash action whose values can be "+
+					"recovered by brute force. use the pseudonymize action instead", rule.Name)
+			}
+			redactor.Rules = append(redactor.Rules, rule)
+		}
+		processors = append(processors, redactor)
+	}
+	if config.Dedup.Enabled {
+		processors = append(processors, config.Dedup.toDeduplicator())
+	}
+	if config.Sampling.enabled() {
+		processors = append(processors, config.Sampling.toSampler())
+	}
+	return processors, nil
+}
+
+// newHttpClient creates the http client used by all APIs. If a client certificate is configured, it is reloaded
+// whenever the certificate or key file changes.
+func newHttpClient(config logsightConfig, proxyURL *url.URL, tlsConfig *tlscommon.TLSConfig, observer outputs.Observer, logger *logp.Logger) (*http.Client, error) {
 	proxy := http.ProxyFromEnvironment
 	if proxyURL != nil {
 		proxy = http.ProxyURL(proxyURL)
@@ -33,7 +190,15 @@ func NewClient(config logsightConfig, hostURL *url.URL, proxyURL *url.URL, tlsCo
 	var dialer, tlsDialer transport.Dialer
 
 	dialer = transport.NetDialer(config.Timeout * time.Second)
-	tlsDialer = transport.TLSDialer(dialer, tlsConfig, config.Timeout*time.Second)
+	if config.hasClientCertificate() {
+		reloader, err := newCertificateReloader(tlsConfig, config.TLS.Certificate, logger)
+		if err != nil {
+			return nil, err
+		}
+		tlsDialer = reloader.TLSDialer(dialer, config.Timeout*time.Second)
+	} else {
+		tlsDialer = transport.TLSDialer(dialer, tlsConfig, config.Timeout*time.Second)
+	}
 
 	if st := observer; st != nil {
 		dialer = transport.StatsDialer(dialer, st)
@@ -48,60 +213,147 @@ func NewClient(config logsightConfig, hostURL *url.URL, proxyURL *url.URL, tlsCo
 		},
 		Timeout: config.Timeout * time.Second,
 	}
+	return httpClient, nil
+}
 
-	baseApi := &api.BaseApi{HttpClient: httpClient, Url: hostURL}
-	userApi := &api.UserApi{LoginApi: &api.LoginApi{BaseApi: baseApi}}
-	user, err := userApi.Login(config.Email, config.Password)
-	if err != nil {
-		return nil, err
+// newAuthenticator creates the api.Authenticator for the configured auth mode. In password mode, the credentials
+// are verified by a login before the authenticator is returned.
+func newAuthenticator(config logsightConfig, baseApi *api.BaseApi) (api.Authenticator, error) {
+	switch config.Auth.Mode {
+	case AuthModePassword:
+		userApi := &api.UserApi{LoginApi: &api.LoginApi{BaseApi: baseApi}}
+		user, err := userApi.Login(config.Email, config.Password)
+		if err != nil {
+			return nil, err
+		}
+		return api.BasicAuthenticator{User: user}, nil
+	case AuthModeToken:
+		return api.TokenAuthenticator{Token: config.Auth.Token, Scheme: config.Auth.Scheme}, nil
+	case AuthModeNone, AuthModeCertificate:
+		// With a client certificate the authentication is done during the TLS handshake
+		return api.NoneAuthenticator{}, nil
+	default:
+		return nil, fmt.Errorf("invalid auth mode %v", config.Auth.Mode)
 	}
-	logApi := &api.LogApi{BaseApi: baseApi, User: user}
-	logSender := api.LogSender{
-		LogApi: logApi,
+}
+
+func (c *Client) Connect() error {
+	if c.deadLetter != nil {
+		if err := c.deadLetter.open(); err != nil {
+			return fmt.Errorf("%w; failed to open the dead letter file", err)
+		}
+	}
+	processorMetrics.add(c)
+	if c.spool != nil && c.spoolDone == nil {
+		c.spoolDone = make(chan struct{})
+		c.loops.Add(1)
+		go c.drainSpoolPeriodically(c.spoolDone)
 	}
+	if c.hasFlushers() && c.flushDone == nil {
+		c.flushDone = make(chan struct{})
+		c.loops.Add(1)
+		go c.flushPeriodically(c.flushDone)
+	}
+	return nil
+}
 
-	// Create mappers
-	var timestampMapper *mapper.StringMapper
-	if config.TimestampKey == "" {
-		timestampMapper = &mapper.StringMapper{Mapper: mapper.EventTimeMapper{}}
-	} else {
-		timestampMapper = &mapper.StringMapper{Mapper: mapper.KeyMapper{Key: config.TimestampKey}}
+// Close sends the logs which are still held back by the processors before the client is closed, unless the last
+// publish failed. Then the client is closed by the pipeline to connect it again, and the logs are kept until the
+// API is reachable again.
+func (c *Client) Close() error {
+	if c.spoolDone != nil {
+		close(c.spoolDone)
+		c.spoolDone = nil
 	}
-	var levelMapper *mapper.StringMapper
-	if config.LevelKey == "" {
-		levelMapper = &mapper.StringMapper{Mapper: mapper.ConstantStringMapper{ConstantString: DefaultLevel}}
-	} else {
-		levelMapper = &mapper.StringMapper{Mapper: mapper.KeyMapper{Key: config.LevelKey}}
+	if c.flushDone != nil {
+		close(c.flushDone)
+		c.flushDone = nil
+	}
+	c.loops.Wait()
+	if atomic.LoadInt32(&c.publishFailed) == 0 {
+		c.flush(true)
 	}
-	messageMapper := &mapper.StringMapper{Mapper: mapper.KeyMapper{Key: config.MessageKey}}
-	tagsMapper := &mapper.MultipleKeyValueStringMapper{
-		Mapper: mapper.MultipleKeyValueMapper{KeyValuePairs: config.TagsMapping},
+	processorMetrics.remove(c)
+	c.logSender.Close()
+	if c.deadLetter != nil {
+		if err := c.deadLetter.Close(); err != nil {
+			c.logger.Errorf("closing the dead letter file failed, Error: %v", err)
+		}
 	}
+	return nil
+}
 
-	logMapper := &mapper.LogMapper{
-		TimestampMapper: timestampMapper,
-		MessageMapper:   messageMapper,
-		LevelMapper:     levelMapper,
-		TagsMapper:      tagsMapper,
+func (c *Client) hasFlushers() bool {
+	for _, p := range c.processors {
+		if _, ok := p.(processor.Flusher); ok {
+			return true
+		}
 	}
+	return false
+}
 
-	client := &Client{
-		logMapper: logMapper,
-		logSender: logSender,
-		observer:  &observer,
-		logger:    logger,
+// flushPeriodically sends the logs which are held back by the processors also if no new events are published
+func (c *Client) flushPeriodically(done chan struct{}) {
+	defer c.loops.Done()
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			c.flush(false)
+		}
 	}
+}
 
-	return client, nil
+// flush sends the logs which are held back by the processors and due, or all of them if final is set. Since they
+// belong to no batch of the pipeline which could be retried, they are kept until they could be sent, at most
+// maxUnflushedLogs. On a final flush, they are dropped if they cannot be sent.
+func (c *Client) flush(final bool) {
+	c.flushMutex.Lock()
+	defer c.flushMutex.Unlock()
+	if logs := c.processors.Flush(final); len(logs) > 0 {
+		c.unflushed = append(c.unflushed, api.NewLogBatch(logs))
+	}
+	c.sendUnflushed(final)
 }
 
-func (c *Client) Connect() error {
-	return nil
+// sendUnflushed sends the unflushed batches in order. The flushMutex must be held.
+func (c *Client) sendUnflushed(final bool) {
+	for len(c.unflushed) > 0 {
+		batch := c.unflushed[0]
+		err := c.publish(batch)
+		if err != nil && !final {
+			c.logger.Warnf("held back logs could not be sent and are retried, Error: %v", err)
+			c.limitUnflushed()
+			return
+		}
+		if err != nil {
+			c.logger.Errorf("dropping %v held back logs which could not be sent, Error: %v", len(batch.Logs), err)
+			c.reportDropped(len(batch.Logs))
+		}
+		batch.Release()
+		c.unflushed = c.unflushed[1:]
+	}
+	c.unflushed = nil
 }
 
-func (c *Client) Close() error {
-	c.logSender.Close()
-	return nil
+// limitUnflushed drops the oldest unflushed batches if they hold more than maxUnflushedLogs
+func (c *Client) limitUnflushed() {
+	count := 0
+	for _, batch := range c.unflushed {
+		count += len(batch.Logs)
+	}
+	for count > maxUnflushedLogs {
+		batch := c.unflushed[0]
+		c.logger.Errorf("more than %v held back logs could not be sent. dropping %v of them",
+			maxUnflushedLogs, len(batch.Logs))
+		c.reportDropped(len(batch.Logs))
+		count -= len(batch.Logs)
+		batch.Release()
+		c.unflushed = c.unflushed[1:]
+	}
 }
 
 func (c *Client) String() string {
@@ -111,26 +363,112 @@ func (c *Client) String() string {
 // Publish sends events to the clients sink.
 func (c *Client) Publish(_ context.Context, batch publisher.Batch) error {
 	events := batch.Events()
-	mappedLogs, err := c.eventsToMappedLogs(events)
-	if err != nil {
-		c.logger.Debugf("%v", err)
-	}
-	if mappedLogs != nil {
-		err := c.publish(mappedLogs)
-		if err == nil {
+	id := batchId(events)
+	logBatch, retried := c.failed.get(id)
+	if !retried {
+		mappedLogs, err := c.eventsToMappedLogs(events)
+		if err != nil {
+			c.logger.Debugf("%v", err)
+		}
+		if len(mappedLogs) == 0 {
+			// the mapping failed for all events, they cannot succeed on retry
 			batch.ACK()
 			return nil
-		} else {
-			batch.RetryEvents(events)
-			return err
+		}
+		logBatch = &api.LogBatch{Id: id, Logs: c.processors.Process(mappedLogs)}
+		c.reportFiltered()
+	}
+	if len(logBatch.Logs) == 0 {
+		// all logs were dropped by the processors
+		batch.ACK()
+		return nil
+	}
+	err := c.publish(logBatch)
+	if err == nil {
+		atomic.StoreInt32(&c.publishFailed, 0)
+		c.failed.remove(id)
+		logBatch.Release()
+		batch.ACK()
+		c.retryUnflushed()
+		return nil
+	} else {
+		atomic.StoreInt32(&c.publishFailed, 1)
+		if evicted := c.failed.put(logBatch); evicted != nil {
+			c.logger.Warnf("more than %v batches failed. the logs of batch %v are processed again when retried",
+				maxFailedBatches, evicted.Id)
+			evicted.Release()
+		}
+		batch.RetryEvents(events)
+		return err
+	}
+}
+
+// retryUnflushed sends the unflushed batches once the API is reachable again
+func (c *Client) retryUnflushed() {
+	c.flushMutex.Lock()
+	defer c.flushMutex.Unlock()
+	c.sendUnflushed(false)
+}
+
+// failedBatches keeps failed batches by their id until they are published. A retried batch is not processed again,
+// since the processors keep state across batches, e.g. for deduplication, and its payload is already encoded. Other
+// batches can be published before the retry, so several failed batches are kept, at most maxFailedBatches.
+type failedBatches struct {
+	batches map[uuid.UUID]*api.LogBatch
+	// ids are the ids of the batches in the order they failed first
+	ids []uuid.UUID
+}
+
+func (fb *failedBatches) get(id uuid.UUID) (*api.LogBatch, bool) {
+	batch, exists := fb.batches[id]
+	return batch, exists
+}
+
+// put keeps the batch and returns the oldest batch if it had to be evicted
+func (fb *failedBatches) put(batch *api.LogBatch) *api.LogBatch {
+	if fb.batches == nil {
+		fb.batches = map[uuid.UUID]*api.LogBatch{}
+	}
+	if _, exists := fb.batches[batch.Id]; exists {
+		fb.batches[batch.Id] = batch
+		return nil
+	}
+	fb.batches[batch.Id] = batch
+	fb.ids = append(fb.ids, batch.Id)
+	if len(fb.ids) <= maxFailedBatches {
+		return nil
+	}
+	evicted := fb.batches[fb.ids[0]]
+	fb.remove(fb.ids[0])
+	return evicted
+}
+
+func (fb *failedBatches) remove(id uuid.UUID) {
+	if _, exists := fb.batches[id]; !exists {
+		return
+	}
+	delete(fb.batches, id)
+	for i, failedId := range fb.ids {
+		if failedId == id {
+			fb.ids = append(fb.ids[:i], fb.ids[i+1:]...)
+			break
 		}
 	}
-	return nil
 }
 
+func (fb *failedBatches) len() int {
+	return len(fb.ids)
+}
+
+// eventsToMappedLogs maps the events to logs. Events whose mapping failed are reported as dropped.
 func (c *Client) eventsToMappedLogs(events []publisher.Event) ([]*api.Log, error) {
 	mappedLogs, failedEvents := c.logMapper.ToLogs(events)
+	if droppedTags := c.logMapper.TakeDroppedTags(); droppedTags > 0 {
+		c.logger.Warnf("dropped %v tags whose values could not be converted to strings", droppedTags)
+	}
 	if failedEvents != nil {
+		c.logger.Warnf("dropped %v out of %v events whose mapping failed", len(failedEvents), len(events))
+		c.reportDropped(len(failedEvents))
 		if len(failedEvents) == len(events) {
 			return nil, fmt.Errorf("mapping failed for all %v logs. errors: %v",
 				len(events), strings.Join(c.ErrorsAsStrings(failedEvents), "\n"))
@@ -150,11 +488,130 @@ func (c *Client) ErrorsAsStrings(failedMappings []*mapper.FailedMapping) []strin
 	return errStrings
 }
 
-func (c Client) publish(logs []*api.Log) error {
-	return c.logSender.Send(logs)
+func (c *Client) publish(batch *api.LogBatch) error {
+	if c.spool != nil {
+		return c.publishWithSpool(batch)
+	}
+	return c.send(batch)
+}
+
+// send sends the batch. Logs which were rejected by the API are dead-lettered and reported as dropped. If the API
+// rejected the whole batch because of them, the remaining logs are sent again.
+func (c *Client) send(batch *api.LogBatch) error {
+	for {
+		err := c.logSender.Send(batch)
+		var rejectedErr api.RejectedLogsError
+		if !errors.As(err, &rejectedErr) {
+			return err
+		}
+		c.deadLetterRejected(batch, rejectedErr.Rejected)
+		if rejectedErr.Ingested || len(batch.Logs) == 0 {
+			return nil
+		}
+	}
+}
+
+// deadLetterRejected removes the rejected logs from the batch. The reasons are logged without the content of the
+// logs, which is only written to the dead letter file.
+func (c *Client) deadLetterRejected(batch *api.LogBatch, rejected map[int]string) {
+	indices := make([]int, 0, len(rejected))
+	for index := range rejected {
+		indices = append(indices, index)
+	}
+	sort.Ints(indices)
+	logs := batch.Remove(indices)
+	for i, log := range logs {
+		reason := rejected[indices[i]]
+		c.logger.Warnf("log %v of batch %v was rejected, Error: %v", indices[i], batch.Id, reason)
+		if c.deadLetter != nil {
+			if err := c.deadLetter.Write(batch.Id, log, reason); err != nil {
+				c.logger.Errorf("writing a rejected log to the dead letter file failed, Error: %v", err)
+			}
+		}
+	}
+	c.reportDropped(len(logs))
+}
+
+// batchId returns the id which was stored in the event metadata when the events were published before. Otherwise, a
+// new id is generated and stored in the metadata of all events. Retried events keep their metadata, so a batch is
+// sent with the same id on every retry.
+func batchId(events []publisher.Event) uuid.UUID {
+	if id, ok := storedBatchId(events); ok {
+		return id
+	}
+	id := uuid.New()
+	for i := range events {
+		if events[i].Content.Meta == nil {
+			events[i].Content.Meta = common.MapStr{}
+		}
+		events[i].Content.Meta[batchIdMetaKey] = id.String()
+	}
+	return id
 }
 
-//TODO error handling
-func (c Client) isRetryError(err error) bool {
+// storedBatchId returns the stored id if all events have the same one.
+func storedBatchId(events []publisher.Event) (uuid.UUID, bool) {
+	if len(events) == 0 {
+		return uuid.UUID{}, false
+	}
+	first, ok := events[0].Content.Meta[batchIdMetaKey].(string)
+	if !ok {
+		return uuid.UUID{}, false
+	}
+	for _, event := range events[1:] {
+		if id, ok := event.Content.Meta[batchIdMetaKey].(string); !ok || id != first {
+			return uuid.UUID{}, false
+		}
+	}
+	id, err := uuid.Parse(first)
+	return id, err == nil
+}
+
+// isUnreachableError returns true if the API could not be reached, is temporarily unavailable or throttles. Requests
+// which were rejected by the API are not affected.
+func isUnreachableError(err error) bool {
+	var statusErr api.StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= http.StatusInternalServerError ||
+			statusErr.StatusCode == http.StatusRequestTimeout || statusErr.StatusCode == http.StatusTooManyRequests
+	}
 	return true
 }
+
+// isRejectedError returns true if the API rejected the content of the batch, so sending it again cannot succeed
+func isRejectedError(err error) bool {
+	var statusErr api.StatusError
+	if !errors.As(err, &statusErr) {
+		return false
+	}
+	switch statusErr.StatusCode {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity:
+		return true
+	default:
+		return false
+	}
+}
+
+// isAuthError returns true if the API did not accept the credentials, e.g. because a token expired
+func isAuthError(err error) bool {
+	var statusErr api.StatusError
+	return errors.As(err, &statusErr) &&
+		(statusErr.StatusCode == http.StatusUnauthorized || statusErr.StatusCode == http.StatusForbidden)
+}
+
+// reportFiltered reports the logs which were dropped by filters
+func (c *Client) reportFiltered() {
+	for _, p := range c.processors {
+		if filter, ok := p.(*processor.Filter); ok {
+			if dropped := filter.TakeDropped(); dropped > 0 {
+				c.reportDropped(dropped)
+			}
+		}
+	}
+}
+
+func (c *Client) reportDropped(count int) {
+	if c.observer != nil && *c.observer != nil {
+		(*c.observer).Dropped(count)
+	}
+}
@@ -0,0 +1,77 @@
+package plugin
+
+import (
+	"encoding/json"
+	"github.com/aiops/logsight-filebeat/plugin/api"
+	"github.com/google/uuid"
+	"os"
+	"sync"
+	"time"
+)
+
+// deadLetterFile appends logs which were rejected by the API to a file, one json record per line. The file is closed
+// with the client and opened again when the client connects, since the pipeline closes the client after every failed
+// publish.
+type deadLetterFile struct {
+	mutex sync.Mutex
+	path  string
+	// file is nil while the client is closed
+	file *os.File
+}
+
+type deadLetterRecord struct {
+	Time    time.Time `json:"time"`
+	BatchId uuid.UUID `json:"batchId"`
+	Reason  string    `json:"reason"`
+	Log     *api.Log  `json:"log"`
+}
+
+// openDeadLetterFile opens the file for appending.
+func openDeadLetterFile(path string) (*deadLetterFile, error) {
+	df := &deadLetterFile{path: path}
+	if err := df.open(); err != nil {
+		return nil, err
+	}
+	return df, nil
+}
+
+// open opens the file unless it is open already. It is only readable by the owner, since the logs can contain
+// sensitive data.
+func (df *deadLetterFile) open() error {
+	df.mutex.Lock()
+	defer df.mutex.Unlock()
+	if df.file != nil {
+		return nil
+	}
+	file, err := os.OpenFile(df.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	df.file = file
+	return nil
+}
+
+func (df *deadLetterFile) Write(batchId uuid.UUID, log *api.Log, reason string) error {
+	record, err := json.Marshal(deadLetterRecord{Time: time.Now().UTC(), BatchId: batchId, Reason: reason, Log: log})
+	if err != nil {
+		return err
+	}
+	df.mutex.Lock()
+	defer df.mutex.Unlock()
+	if df.file == nil {
+		return os.ErrClosed
+	}
+	_, err = df.file.Write(append(record, '\n'))
+	return err
+}
+
+func (df *deadLetterFile) Close() error {
+	df.mutex.Lock()
+	defer df.mutex.Unlock()
+	if df.file == nil {
+		return nil
+	}
+	err := df.file.Close()
+	df.file = nil
+	return err
+}
@@ -0,0 +1,94 @@
+package plugin
+
+import (
+	"github.com/aiops/logsight-filebeat/plugin/api"
+	"time"
+)
+
+// publishWithSpool sends the batch after all spooled batches were sent, so that the order of the logs is kept. If
+// the API is unreachable, the batch is appended to the spool instead.
+func (c *Client) publishWithSpool(batch *api.LogBatch) error {
+	c.spoolMutex.Lock()
+	defer c.spoolMutex.Unlock()
+
+	if err := c.drainSpool(); err != nil {
+		c.logger.Debugf("spool could not be drained, Error: %v", err)
+		return c.spoolBatch(batch)
+	}
+	err := c.send(batch)
+	if err != nil && isUnreachableError(err) {
+		c.logger.Warnf("logsight is unreachable. spooling %v logs, Error: %v", len(batch.Logs), err)
+		return c.spoolBatch(batch)
+	}
+	return err
+}
+
+func (c *Client) spoolBatch(batch *api.LogBatch) error {
+	evicted, err := c.spool.Append(batch)
+	if evicted > 0 {
+		c.logger.Warnf("spool max size reached. dropped the %v oldest spooled logs", evicted)
+		c.reportDropped(evicted)
+	}
+	return err
+}
+
+// drainSpool sends the spooled batches from oldest to newest. Batches whose content is rejected by the API are
+// dropped, otherwise they would block the spool forever. On all other errors, e.g. if the API is unreachable, throttles
+// or does not accept the credentials, it stops and returns the error, so the batch is sent again later.
+func (c *Client) drainSpool() error {
+	for c.spool.Len() > 0 {
+		batch, err := c.spool.Peek()
+		if err != nil {
+			c.logger.Errorf("dropping unreadable spooled batch, Error: %v", err)
+			if err := c.spool.Pop(); err != nil {
+				return err
+			}
+			continue
+		}
+		count := len(batch.Logs)
+		if err := c.send(batch); err != nil {
+			if !isRejectedError(err) {
+				if isAuthError(err) {
+					c.logger.Warnf("stopped draining the spool since logsight denied the access, Error: %v", err)
+				}
+				if len(batch.Logs) < count {
+					// The rejected logs were dead-lettered already and must not be sent again
+					if err := c.spool.ReplaceOldest(batch); err != nil {
+						c.logger.Errorf("rewriting spooled batch failed, Error: %v", err)
+					}
+				}
+				return err
+			}
+			c.logger.Errorf("dropping spooled batch of %v logs which was rejected, Error: %v", len(batch.Logs), err)
+			c.reportDropped(len(batch.Logs))
+		}
+		batch.Release()
+		if err := c.spool.Pop(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// drainSpoolPeriodically drains the spool also if no new events are published.
+func (c *Client) drainSpoolPeriodically(done chan struct{}) {
+	defer c.loops.Done()
+	ticker := time.NewTicker(c.spoolRetryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			c.spoolMutex.Lock()
+			if c.spool.Len() > 0 {
+				if err := c.drainSpool(); err != nil {
+					c.logger.Debugf("spool could not be drained, Error: %v", err)
+				} else {
+					c.logger.Infof("spool drained")
+				}
+			}
+			c.spoolMutex.Unlock()
+		}
+	}
+}
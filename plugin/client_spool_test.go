@@ -0,0 +1,205 @@
+package plugin
+
+import (
+	"fmt"
+	"github.com/aiops/logsight-filebeat/plugin/api"
+	"github.com/aiops/logsight-filebeat/plugin/api/apitest"
+	"github.com/elastic/beats/v7/libbeat/outputs"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newSpoolClient(t *testing.T, server *apitest.Server) *Client {
+	return newTestClient(t, server, func(config *logsightConfig) {
+		config.Spool.Path = filepath.Join(t.TempDir(), "spool")
+		config.Spool.RetryInterval = 10 * time.Millisecond
+	})
+}
+
+func spoolTestBatch(name string) *api.LogBatch {
+	return api.NewLogBatch([]*api.Log{{
+		Timestamp: "2022-04-04T09:00:35+00:00",
+		Message:   name,
+		Level:     "INFO",
+		Tags:      map[string]string{},
+	}})
+}
+
+func receivedMessages(server *apitest.Server) []string {
+	var messages []string
+	for _, log := range server.Logs() {
+		messages = append(messages, log.Message)
+	}
+	return messages
+}
+
+func TestClient_publishWithSpool(t *testing.T) {
+	server := apitest.NewServer()
+	defer server.Close()
+	client := newSpoolClient(t, server)
+	defer func() { _ = client.Close() }()
+
+	// logsight is unreachable, so the batches are spooled
+	server.FailWith(http.StatusServiceUnavailable)
+	for _, name := range []string{"first", "second"} {
+		if err := client.publish(spoolTestBatch(name)); err != nil {
+			t.Fatalf("publish() error = %v", err)
+		}
+	}
+	assert.Equal(t, 2, client.spool.Len())
+	assert.Empty(t, server.Logs())
+
+	// logsight recovered, the spooled batches are sent before the new one
+	server.FailWith(0)
+	if err := client.publish(spoolTestBatch("third")); err != nil {
+		t.Fatalf("publish() error = %v", err)
+	}
+	assert.Equal(t, 0, client.spool.Len())
+	assert.Equal(t, []string{"first", "second", "third"}, receivedMessages(server))
+
+	// rejected batches are not spooled
+	server.FailWith(http.StatusBadRequest)
+	if err := client.publish(spoolTestBatch("rejected")); err == nil {
+		t.Errorf("publish() error = nil, want error")
+	}
+	assert.Equal(t, 0, client.spool.Len())
+}
+
+func TestClient_drainSpoolPeriodically(t *testing.T) {
+	server := apitest.NewServer()
+	defer server.Close()
+	client := newSpoolClient(t, server)
+
+	server.FailWith(http.StatusBadGateway)
+	if err := client.publish(spoolTestBatch("spooled")); err != nil {
+		t.Fatalf("publish() error = %v", err)
+	}
+	server.FailWith(0)
+
+	_ = client.Connect()
+	defer func() { _ = client.Close() }()
+	deadline := time.Now().Add(5 * time.Second)
+	for client.spool.Len() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 0, client.spool.Len())
+	assert.Equal(t, []string{"spooled"}, receivedMessages(server))
+}
+
+func Test_isUnreachableError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "network error", err: fmt.Errorf("dial tcp: connection refused"), want: true},
+		{name: "service unavailable", err: api.StatusError{StatusCode: http.StatusServiceUnavailable}, want: true},
+		{
+			name: "wrapped internal server error",
+			err:  fmt.Errorf("%w; wrapped", api.StatusError{StatusCode: http.StatusInternalServerError}),
+			want: true,
+		},
+		{name: "too many requests", err: api.StatusError{StatusCode: http.StatusTooManyRequests}, want: true},
+		{name: "request timeout", err: api.StatusError{StatusCode: http.StatusRequestTimeout}, want: true},
+		{name: "bad request", err: api.StatusError{StatusCode: http.StatusBadRequest}, want: false},
+		{name: "unauthorized", err: api.StatusError{StatusCode: http.StatusUnauthorized}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isUnreachableError(tt.err); got != tt.want {
+				t.Errorf("isUnreachableError() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_isRejectedError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "bad request", err: api.StatusError{StatusCode: http.StatusBadRequest}, want: true},
+		{name: "payload too large", err: api.StatusError{StatusCode: http.StatusRequestEntityTooLarge}, want: true},
+		{
+			name: "wrapped unprocessable entity",
+			err:  fmt.Errorf("%w; wrapped", api.StatusError{StatusCode: http.StatusUnprocessableEntity}),
+			want: true,
+		},
+		{name: "unauthorized", err: api.StatusError{StatusCode: http.StatusUnauthorized}, want: false},
+		{name: "forbidden", err: api.StatusError{StatusCode: http.StatusForbidden}, want: false},
+		{name: "too many requests", err: api.StatusError{StatusCode: http.StatusTooManyRequests}, want: false},
+		{name: "network error", err: fmt.Errorf("dial tcp: connection refused"), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRejectedError(tt.err); got != tt.want {
+				t.Errorf("isRejectedError() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClient_drainSpool_keepsBatches(t *testing.T) {
+	server := apitest.NewServer()
+	defer server.Close()
+	client := newTestClient(t, server, func(config *logsightConfig) {
+		config.Spool.Path = filepath.Join(t.TempDir(), "spool")
+		config.Spool.RetryInterval = time.Hour
+	})
+	defer func() { _ = client.Close() }()
+
+	server.FailWith(http.StatusServiceUnavailable)
+	if err := client.publish(spoolTestBatch("spooled")); err != nil {
+		t.Fatalf("publish() error = %v", err)
+	}
+
+	// Throttling and denied access keep the spooled batch, a rejected content drops it
+	for _, status := range []int{http.StatusTooManyRequests, http.StatusUnauthorized, http.StatusForbidden} {
+		server.FailWith(status)
+		assert.Error(t, client.drainSpool())
+		assert.Equal(t, 1, client.spool.Len(), "status %v", status)
+	}
+	server.FailWith(http.StatusUnprocessableEntity)
+	assert.NoError(t, client.drainSpool())
+	assert.Equal(t, 0, client.spool.Len())
+}
+
+func TestClient_drainSpool_rewritesPartiallyRejectedBatch(t *testing.T) {
+	server := apitest.NewServer()
+	defer server.Close()
+	client := newTestClient(t, server, func(config *logsightConfig) {
+		config.Spool.Path = filepath.Join(t.TempDir(), "spool")
+		config.Spool.RetryInterval = time.Hour
+	})
+	defer func() { _ = client.Close() }()
+	observer := &droppedObserver{Observer: outputs.NewNilObserver()}
+	var clientObserver outputs.Observer = observer
+	client.observer = &clientObserver
+
+	server.FailWith(http.StatusServiceUnavailable)
+	batch := spoolTestBatch("valid")
+	batch.Logs = append(batch.Logs, &api.Log{Timestamp: "2022-04-04T09:00:36+00:00", Level: "INFO"})
+	if err := client.publish(batch); err != nil {
+		t.Fatalf("publish() error = %v", err)
+	}
+
+	// The invalid log is rejected, but the response to the resent valid log gets lost
+	server.FailWith(0)
+	server.LoseResponses(1)
+	assert.Error(t, client.drainSpool())
+	spooled, _ := client.spool.Peek()
+	if assert.NotNil(t, spooled) {
+		assert.Len(t, spooled.Logs, 1)
+	}
+
+	// The rejected log is not dead-lettered again, the valid log is recognized as duplicate
+	assert.NoError(t, client.drainSpool())
+	assert.Equal(t, 1, observer.dropped)
+	assert.Equal(t, []string{"valid"}, receivedMessages(server))
+	assert.Equal(t, 1, server.Duplicates())
+}
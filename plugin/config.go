@@ -3,34 +3,294 @@ package plugin
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/aiops/logsight-filebeat/plugin/api"
 	"github.com/aiops/logsight-filebeat/plugin/mapper"
+	"github.com/aiops/logsight-filebeat/plugin/processor"
+	"github.com/elastic/beats/v7/libbeat/common/cfgtype"
 	"github.com/elastic/beats/v7/libbeat/common/transport/tlscommon"
+	"github.com/elastic/beats/v7/libbeat/conditions"
+	"io/ioutil"
+	"net/url"
+	"os"
 	"regexp"
+	"strings"
 	"time"
 )
 
-const DefaultLevel = "INFO"
+const (
+	DefaultLevel      = "INFO"
+	DefaultMessageKey = "message"
+)
+
+// Environment variables which are used as credentials if neither the config nor the keystore provides them.
+const (
+	EmailEnv    = "LOGSIGHT_EMAIL"
+	PasswordEnv = "LOGSIGHT_PASSWORD"
+	TokenEnv    = "LOGSIGHT_TOKEN"
+
+	PseudonymizationKeyEnv = "LOGSIGHT_PSEUDONYMIZATION_KEY"
+)
+
+// Authentication modes which can be set in auth.mode. The certificate mode authenticates with the client certificate
+// configured in tls.certificate and tls.key.
+const (
+	AuthModePassword    = "password"
+	AuthModeToken       = "token"
+	AuthModeNone        = "none"
+	AuthModeCertificate = "certificate"
+)
+
+// redacted replaces secrets whenever a config is printed
+const redacted = "[redacted]"
 
 type logsightConfig struct {
 	Url          string            `config:"url" validate:"required"`
-	Email        string            `config:"email" validate:"required"`
-	Password     string            `config:"password" validate:"required"`
-	MessageKey   string            `config:"message_key"`
-	TimestampKey string            `config:"timestamp_key"`
-	LevelKey     string            `config:"level_key"`
-	TagsMapping  map[string]string `config:"tags_mapping"`
+	Email        string            `config:"email"`
+	Password     string            `config:"password"`
+	PasswordFile string            `config:"password_file"`
+	Auth         authConfig        `config:"auth"`
 	TLS          *tlscommon.Config `config:"tls"`
+	Spool        spoolConfig       `config:"spool"`
 	ProxyURL     string            `config:"proxy_url"`
 	BatchSize    int               `config:"batch_size"`
 	MaxRetries   int               `config:"max_retries"`
 	Timeout      time.Duration     `config:"timeout"`
+	// MappingWorkers map the events of large batches concurrently, e.g. one per CPU core
+	MappingWorkers int `config:"mapping_workers" validate:"min=0"`
+	// ApiFormat is the format in which batches are sent, either a json array or ndjson with errors reported per line
+	ApiFormat string `config:"api_format"`
+	// DeadLetter stores the logs which were rejected by the API
+	DeadLetter deadLetterConfig `config:"dead_letter"`
+
+	Mapping mappingConfig `config:",inline"`
+	// Mappings are conditional mapping rules. The first rule whose condition matches an event maps it, events which
+	// match no rule are mapped by the top level mapping config.
+	Mappings []mappingRuleConfig `config:"mappings"`
+	// Hints are read from the pod annotations added by kubernetes autodiscover or add_kubernetes_metadata
+	Hints hintsConfig `config:"hints"`
+	// Filter drops mapped logs before they are processed further
+	Filter filterConfig `config:"filter"`
+	// Redaction rules are applied in order to the messages and tags of the mapped logs before they are sent
+	Redaction []redactionRuleConfig `config:"redaction"`
+	// PseudonymizationKey is the secret of the pseudonymize action of redaction rules. It must be the same on all
+	// hosts, so their tokens can be correlated.
+	PseudonymizationKey     string `config:"pseudonymization_key"`
+	PseudonymizationKeyFile string `config:"pseudonymization_key_file"`
+	// Dedup collapses repeated logs after the redaction
+	Dedup dedupConfig `config:"dedup"`
+	// Sampling reduces the logs of chatty levels after the deduplication
+	Sampling samplingConfig `config:"sampling"`
+}
+
+func (lc *logsightConfig) Validate() error {
+	switch lc.ApiFormat {
+	case api.FormatArray, api.FormatNdjson:
+		return nil
+	default:
+		return fmt.Errorf("invalid api format %v. must be one of %v, %v", lc.ApiFormat, api.FormatArray, api.FormatNdjson)
+	}
 }
 
+// String returns the config as json. Secrets are redacted, so the result is safe to be logged.
 func (lc *logsightConfig) String() string {
-	strResult, _ := json.Marshal(lc)
+	strResult, _ := json.Marshal(lc.redacted())
 	return string(strResult)
 }
 
+func (lc *logsightConfig) redacted() *logsightConfig {
+	redactedConfig := *lc
+	redactedConfig.Url = redactURL(lc.Url)
+	redactedConfig.ProxyURL = redactURL(lc.ProxyURL)
+	redactedConfig.Password = redactSecret(lc.Password)
+	redactedConfig.Auth.Token = redactSecret(lc.Auth.Token)
+	redactedConfig.PseudonymizationKey = redactSecret(lc.PseudonymizationKey)
+	if lc.TLS != nil {
+		redactedTLS := *lc.TLS
+		redactedTLS.Certificate.Passphrase = redactSecret(lc.TLS.Certificate.Passphrase)
+		// The key can be configured as inline PEM instead of a file path
+		if strings.Contains(lc.TLS.Certificate.Key, "PRIVATE KEY") {
+			redactedTLS.Certificate.Key = redacted
+		}
+		redactedConfig.TLS = &redactedTLS
+	}
+	return &redactedConfig
+}
+
+// redactURL replaces the password of the userinfo of a url. If the url cannot be parsed, the whole userinfo is
+// replaced.
+func redactURL(raw string) string {
+	parsed, err := url.Parse(raw)
+	if err == nil {
+		return parsed.Redacted()
+	}
+	if i := strings.LastIndex(raw, "@"); i >= 0 {
+		return redacted + raw[i:]
+	}
+	return raw
+}
+
+func redactSecret(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return redacted
+}
+
+// authConfig selects how requests are authenticated. The email and password of the password mode are set at the
+// top level of the logsight config.
+type authConfig struct {
+	Mode      string `config:"mode"`
+	Token     string `config:"token"`
+	TokenFile string `config:"token_file"`
+	// Scheme is the prefix of the token in the Authorization header, e.g. Bearer or ApiKey
+	Scheme string `config:"scheme"`
+}
+
+func (ac *authConfig) Validate() error {
+	switch ac.Mode {
+	case AuthModePassword, AuthModeToken, AuthModeNone, AuthModeCertificate:
+		return nil
+	default:
+		return fmt.Errorf("invalid auth mode %v. must be one of %v, %v, %v, %v",
+			ac.Mode, AuthModePassword, AuthModeToken, AuthModeNone, AuthModeCertificate)
+	}
+}
+
+// resolveCredentials sets the credentials needed by the auth mode. Each secret is resolved with the following
+// precedence (highest first):
+//  1. email, password and auth.token as set in the config. This includes references like ${LOGSIGHT_PASSWORD},
+//     which are resolved by libbeat from the beats keystore or the environment.
+//  2. the first line of the file referenced by password_file or auth.token_file
+//  3. the environment variables LOGSIGHT_EMAIL, LOGSIGHT_PASSWORD and LOGSIGHT_TOKEN
+func (lc *logsightConfig) resolveCredentials() error {
+	switch lc.Auth.Mode {
+	case AuthModePassword:
+		return lc.resolvePassword()
+	case AuthModeToken:
+		return lc.resolveToken()
+	case AuthModeCertificate:
+		if !lc.hasClientCertificate() {
+			return fmt.Errorf("auth mode %v requires tls.certificate and tls.key", AuthModeCertificate)
+		}
+		return nil
+	default:
+		return nil
+	}
+}
+
+// resolvePseudonymizationKey sets the key of the pseudonymize action if a redaction rule uses it. The key is resolved
+// with the same precedence as the credentials.
+func (lc *logsightConfig) resolvePseudonymizationKey() error {
+	needed := false
+	for _, rule := range lc.Redaction {
+		needed = needed || rule.Action == processor.ActionPseudonymize
+	}
+	if !needed {
+		return nil
+	}
+	key, err := resolveSecret(lc.PseudonymizationKey, "pseudonymization_key_file", lc.PseudonymizationKeyFile,
+		PseudonymizationKeyEnv)
+	if err != nil {
+		return err
+	}
+	lc.PseudonymizationKey = key
+
+	if lc.PseudonymizationKey == "" {
+		return fmt.Errorf("no pseudonymization key configured. set pseudonymization_key, pseudonymization_key_file "+
+			"or the environment variable %v", PseudonymizationKeyEnv)
+	}
+	return nil
+}
+
+func (lc *logsightConfig) hasClientCertificate() bool {
+	return lc.TLS != nil && lc.TLS.IsEnabled() && lc.TLS.Certificate.Certificate != "" && lc.TLS.Certificate.Key != ""
+}
+
+func (lc *logsightConfig) resolvePassword() error {
+	if lc.Email == "" {
+		lc.Email = os.Getenv(EmailEnv)
+	}
+	password, err := resolveSecret(lc.Password, "password_file", lc.PasswordFile, PasswordEnv)
+	if err != nil {
+		return err
+	}
+	lc.Password = password
+
+	if lc.Email == "" {
+		return fmt.Errorf("no email configured. set email or the environment variable %v", EmailEnv)
+	}
+	if lc.Password == "" {
+		return fmt.Errorf("no password configured. set password, password_file or the environment variable %v",
+			PasswordEnv)
+	}
+	return nil
+}
+
+func (lc *logsightConfig) resolveToken() error {
+	token, err := resolveSecret(lc.Auth.Token, "auth.token_file", lc.Auth.TokenFile, TokenEnv)
+	if err != nil {
+		return err
+	}
+	lc.Auth.Token = token
+
+	if lc.Auth.Token == "" {
+		return fmt.Errorf("no token configured. set auth.token, auth.token_file or the environment variable %v",
+			TokenEnv)
+	}
+	return nil
+}
+
+func resolveSecret(value string, fileOption string, path string, env string) (string, error) {
+	if value != "" {
+		return value, nil
+	}
+	if path != "" {
+		return readSecretFile(fileOption, path)
+	}
+	return os.Getenv(env), nil
+}
+
+func readSecretFile(option string, path string) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		// the error of ReadFile contains only the path but never the file content
+		return "", fmt.Errorf("%w; failed to read %v", err, option)
+	}
+	secret := strings.SplitN(string(content), "\n", 2)[0]
+	secret = strings.TrimRight(secret, "\r")
+	if secret == "" {
+		return "", fmt.Errorf("%v %v is empty", option, path)
+	}
+	return secret, nil
+}
+
+// spoolConfig enables the disk spool if a path is set. Batches are spooled while logsight is unreachable and sent
+// in order once it recovers.
+type spoolConfig struct {
+	Path          string           `config:"path"`
+	MaxSize       cfgtype.ByteSize `config:"max_size"`
+	RetryInterval time.Duration    `config:"retry_interval"`
+}
+
+func (sc *spoolConfig) Validate() error {
+	if sc.Path == "" {
+		return nil
+	}
+	if sc.MaxSize <= 0 {
+		return fmt.Errorf("spool.max_size must be greater than 0")
+	}
+	if sc.RetryInterval <= 0 {
+		return fmt.Errorf("spool.retry_interval must be greater than 0")
+	}
+	return nil
+}
+
+// deadLetterConfig enables the dead letter file if a path is set. Each log which was rejected by the API is appended
+// as json line together with the reason. Without path, only the reasons are logged.
+type deadLetterConfig struct {
+	Path string `config:"path"`
+}
+
 type mapperConf struct {
 	Name         string
 	Key          string
@@ -54,17 +314,504 @@ func (mc *mapperConf) toMapper() (mapper.Mapper, error) {
 	}
 }
 
+// parserConfig configures a parser which extracts tags from the message with either a regex with named capture
+// groups or a dissect pattern.
+type parserConfig struct {
+	Regex         string `config:"regex"`
+	Dissect       string `config:"dissect"`
+	MessageField  string `config:"message_field"`
+	TagsPrefix    string `config:"tags_prefix"`
+	IgnoreFailure bool   `config:"ignore_failure"`
+}
+
+func (pc *parserConfig) Validate() error {
+	if (pc.Regex == "") == (pc.Dissect == "") {
+		return fmt.Errorf("invalid parser config. either regex or dissect must be set")
+	}
+	return nil
+}
+
+func (pc *parserConfig) toMessageParser() (*mapper.MessageParser, error) {
+	var parser mapper.Parser
+	var err error
+	if pc.Regex != "" {
+		parser, err = mapper.NewRegexParser(pc.Regex)
+	} else {
+		parser, err = mapper.NewDissectParser(pc.Dissect)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &mapper.MessageParser{
+		Parser:        parser,
+		MessageField:  pc.MessageField,
+		TagsPrefix:    pc.TagsPrefix,
+		IgnoreFailure: pc.IgnoreFailure,
+	}, nil
+}
+
+// mappingConfig configures how events are mapped to logs
+type mappingConfig struct {
+	// The keys are candidates which are tried in order. The default is used if none of them exists in an event.
+	MessageKey     []string `config:"message_key"`
+	MessageDefault string   `config:"message_default"`
+	// MessageTemplate renders the message from fields of the event. Events which lack fields of the template fall
+	// back to the message keys.
+	MessageTemplate  string            `config:"message_template"`
+	TimestampKey     []string          `config:"timestamp_key"`
+	TimestampDefault string            `config:"timestamp_default"`
+	LevelKey         []string          `config:"level_key"`
+	LevelDefault     string            `config:"level_default"`
+	TagsMapping      map[string]string `config:"tags_mapping"`
+	// TagsPreset adds the tags mappings of presets for the fields of the metadata processors, e.g. kubernetes
+	TagsPreset []string `config:"tags_preset"`
+	// TagsPatterns map all fields whose keys match a glob to tags. The tags are filtered by the include and exclude
+	// globs on their names. Max tags limits all tags of a log, pattern tags are only added while there are less.
+	TagsPatterns []tagPatternConfig `config:"tags_patterns"`
+	TagsInclude  []string           `config:"tags_include"`
+	TagsExclude  []string           `config:"tags_exclude"`
+	MaxTags      int                `config:"max_tags" validate:"min=0"`
+	Parsers      []parserConfig     `config:"parsers"`
+	// TagsCoercion configures how tag values which are not strings are converted
+	TagsCoercion tagsCoercionConfig `config:"tags_coercion"`
+
+	// Format selects a built-in format which is parsed from the message, e.g. logfmt or syslog_rfc5424
+	Format              string `config:"format"`
+	FormatPattern       string `config:"format_pattern"`
+	FormatIgnoreFailure bool   `config:"format_ignore_failure"`
+
+	DecodeJson decodeJsonConfig `config:"decode_json"`
+}
+
+// toLogMapper creates the mappers for the fields of a log. Without keys, the message is mapped from
+// DefaultMessageKey, the timestamp from the event time and the level is DefaultLevel, unless a default is set.
+func (mc *mappingConfig) toLogMapper() (*mapper.LogMapper, error) {
+	messageKeys := mc.MessageKey
+	if len(messageKeys) == 0 {
+		messageKeys = []string{DefaultMessageKey}
+	}
+	messageMapper := keysMapper(messageKeys, mc.MessageDefault, nil)
+	if mc.MessageTemplate != "" {
+		templateMapper, err := mapper.NewTemplateMapper(mc.MessageTemplate)
+		if err != nil {
+			return nil, err
+		}
+		messageMapper = &mapper.StringMapper{
+			Mapper: mapper.FallbackMapper{Mappers: []mapper.Mapper{templateMapper, messageMapper.Mapper}},
+		}
+	}
+	timestampMapper := keysMapper(mc.TimestampKey, mc.TimestampDefault, mapper.EventTimeMapper{})
+	levelMapper := keysMapper(mc.LevelKey, mc.LevelDefault, mapper.ConstantStringMapper{ConstantString: DefaultLevel})
+	tagsMapping, err := mapper.WithTagsPresets(mc.TagsPreset, mc.TagsMapping)
+	if err != nil {
+		return nil, err
+	}
+	tagCoercer := mc.TagsCoercion.toTagCoercer()
+	tagsMapper := &mapper.MultipleKeyValueStringMapper{
+		Mapper:  mapper.MultipleKeyValueMapper{KeyValuePairs: tagsMapping},
+		Coercer: tagCoercer,
+	}
+	messageParsers := make([]*mapper.MessageParser, len(mc.Parsers))
+	for i, parserConf := range mc.Parsers {
+		messageParser, err := parserConf.toMessageParser()
+		if err != nil {
+			return nil, err
+		}
+		messageParsers[i] = messageParser
+	}
+	formatMapper, err := mc.toFormatMapper(messageMapper)
+	if err != nil {
+		return nil, err
+	}
+	patternTagsMapper, err := mc.toPatternTagsMapper()
+	if err != nil {
+		return nil, err
+	}
+	if patternTagsMapper != nil {
+		patternTagsMapper.Coercer = tagCoercer
+	}
+
+	return &mapper.LogMapper{
+		TimestampMapper:   timestampMapper,
+		MessageMapper:     messageMapper,
+		LevelMapper:       levelMapper,
+		TagsMapper:        tagsMapper,
+		MessageParsers:    messageParsers,
+		FormatMapper:      formatMapper,
+		JsonDecoder:       mc.DecodeJson.toJsonDecoder(messageKeys[0]),
+		PatternTagsMapper: patternTagsMapper,
+	}, nil
+}
+
+// toPatternTagsMapper returns nil if neither tags patterns nor max tags are configured. The include and exclude globs
+// require patterns, since they only filter the pattern tags.
+func (mc *mappingConfig) toPatternTagsMapper() (*mapper.PatternTagsMapper, error) {
+	if len(mc.TagsPatterns) == 0 {
+		if len(mc.TagsInclude) > 0 || len(mc.TagsExclude) > 0 {
+			return nil, fmt.Errorf("tags_include and tags_exclude require tags_patterns")
+		}
+		if mc.MaxTags == 0 {
+			return nil, nil
+		}
+	}
+	patterns := make([]*mapper.TagPattern, len(mc.TagsPatterns))
+	for i, patternConf := range mc.TagsPatterns {
+		pattern, err := mapper.NewTagPattern(patternConf.Pattern, patternConf.Prefix)
+		if err != nil {
+			return nil, err
+		}
+		patterns[i] = pattern
+	}
+	return mapper.NewPatternTagsMapper(patterns, mc.TagsInclude, mc.TagsExclude, mc.MaxTags)
+}
+
+// tagPatternConfig maps the fields matching the glob pattern to tags, e.g. kubernetes.labels.* with prefix k8s_ maps
+// kubernetes.labels.app to k8s_app.
+type tagPatternConfig struct {
+	Pattern string `config:"pattern" validate:"required"`
+	Prefix  string `config:"prefix"`
+}
+
+// tagsCoercionConfig configures the conversion of tag values which are not strings. Arrays are joined with the
+// array separator, expanded to one tag per element or dropped. Objects are json encoded or dropped. Empty values
+// keep the defaults of mapper.DefaultTagCoercer.
+type tagsCoercionConfig struct {
+	Arrays         string `config:"arrays"`
+	ArraySeparator string `config:"array_separator"`
+	Objects        string `config:"objects"`
+}
+
+func (tc *tagsCoercionConfig) Validate() error {
+	switch tc.Arrays {
+	case "", mapper.ArrayModeJoin, mapper.ArrayModeExpand, mapper.ArrayModeDrop:
+	default:
+		return fmt.Errorf("invalid tags_coercion.arrays %v. must be one of %v, %v or %v", tc.Arrays,
+			mapper.ArrayModeJoin, mapper.ArrayModeExpand, mapper.ArrayModeDrop)
+	}
+	switch tc.Objects {
+	case "", mapper.ObjectModeJson, mapper.ObjectModeDrop:
+	default:
+		return fmt.Errorf("invalid tags_coercion.objects %v. must be one of %v or %v", tc.Objects,
+			mapper.ObjectModeJson, mapper.ObjectModeDrop)
+	}
+	return nil
+}
+
+func (tc *tagsCoercionConfig) toTagCoercer() *mapper.TagCoercer {
+	coercer := mapper.DefaultTagCoercer
+	if tc.Arrays != "" {
+		coercer.ArrayMode = tc.Arrays
+	}
+	if tc.ArraySeparator != "" {
+		coercer.ArraySeparator = tc.ArraySeparator
+	}
+	if tc.Objects != "" {
+		coercer.ObjectMode = tc.Objects
+	}
+	return &coercer
+}
+
+// keysMapper returns a mapper for the first of the keys which exists in an event. If none of them exists, the
+// default value is returned if it is set. Without keys, the unset mapper is used, unless a default value is set.
+func keysMapper(keys []string, defaultValue string, unset mapper.Mapper) *mapper.StringMapper {
+	var defaultMapper mapper.Mapper
+	if defaultValue != "" {
+		defaultMapper = mapper.ConstantStringMapper{ConstantString: defaultValue}
+	}
+	switch {
+	case len(keys) == 0 && defaultMapper != nil:
+		return &mapper.StringMapper{Mapper: defaultMapper}
+	case len(keys) == 0:
+		return &mapper.StringMapper{Mapper: unset}
+	case len(keys) == 1 && defaultMapper == nil:
+		return &mapper.StringMapper{Mapper: mapper.KeyMapper{Key: keys[0]}}
+	}
+	keyMappers := make([]mapper.Mapper, len(keys))
+	for i, key := range keys {
+		keyMappers[i] = mapper.KeyMapper{Key: key}
+	}
+	return &mapper.StringMapper{Mapper: mapper.FallbackMapper{Mappers: keyMappers, Default: defaultMapper}}
+}
+
+// mappingRuleConfig is a mapping config which applies to the events which match the condition in when, e.g.
+// when.equals.input.type: syslog
+type mappingRuleConfig struct {
+	When    conditions.Config `config:"when" validate:"required"`
+	Mapping mappingConfig     `config:",inline"`
+}
+
+func (rc *mappingRuleConfig) toMappingRule() (*mapper.MappingRule, error) {
+	condition, err := conditions.NewCondition(&rc.When)
+	if err != nil {
+		return nil, fmt.Errorf("%w; invalid when condition", err)
+	}
+	logMapper, err := rc.Mapping.toLogMapper()
+	if err != nil {
+		return nil, err
+	}
+	return &mapper.MappingRule{Condition: condition, Mapper: logMapper}, nil
+}
+
+// hintsConfig enables mapping hints in the annotations of pods, e.g. co.elastic.logs/logsight.level_key: severity.
+// The annotations must be included in the events, e.g. with include_annotations of add_kubernetes_metadata.
+type hintsConfig struct {
+	Enabled bool   `config:"enabled"`
+	Field   string `config:"field"`
+	Prefix  string `config:"prefix"`
+}
+
+func (hc *hintsConfig) Validate() error {
+	if hc.Enabled && (hc.Field == "" || hc.Prefix == "") {
+		return fmt.Errorf("hints.field and hints.prefix must be set if hints are enabled")
+	}
+	return nil
+}
+
+// toAnnotationHints returns nil if hints are disabled
+func (hc *hintsConfig) toAnnotationHints() *mapper.AnnotationHints {
+	if !hc.Enabled {
+		return nil
+	}
+	return &mapper.AnnotationHints{Field: hc.Field, Prefix: hc.Prefix}
+}
+
+// filterConfig keeps the mapped logs which match one of the include rules, if any are set, and none of the exclude
+// rules, e.g. exclude: [{level: [DEBUG]}, {message: ['^GET /health']}]
+type filterConfig struct {
+	Include []filterRuleConfig `config:"include"`
+	Exclude []filterRuleConfig `config:"exclude"`
+}
+
+// toFilter returns nil if no rules are configured
+func (fc *filterConfig) toFilter() (*processor.Filter, error) {
+	if len(fc.Include) == 0 && len(fc.Exclude) == 0 {
+		return nil, nil
+	}
+	include, err := toFilterRules(fc.Include)
+	if err != nil {
+		return nil, fmt.Errorf("%w; invalid filter include rule", err)
+	}
+	exclude, err := toFilterRules(fc.Exclude)
+	if err != nil {
+		return nil, fmt.Errorf("%w; invalid filter exclude rule", err)
+	}
+	return &processor.Filter{Include: include, Exclude: exclude}, nil
+}
+
+// filterRuleConfig matches logs with one of the levels, a message which matches one of the regexes and all tags
+type filterRuleConfig struct {
+	Level   []string          `config:"level"`
+	Message []string          `config:"message"`
+	Tags    map[string]string `config:"tags"`
+}
+
+func (rc *filterRuleConfig) Validate() error {
+	if len(rc.Level) == 0 && len(rc.Message) == 0 && len(rc.Tags) == 0 {
+		return fmt.Errorf("invalid filter rule. at least one of level, message or tags must be set")
+	}
+	return nil
+}
+
+func toFilterRules(configs []filterRuleConfig) ([]*processor.FilterRule, error) {
+	rules := make([]*processor.FilterRule, len(configs))
+	for i, ruleConf := range configs {
+		rule, err := processor.NewFilterRule(ruleConf.Level, ruleConf.Message, ruleConf.Tags)
+		if err != nil {
+			return nil, err
+		}
+		rules[i] = rule
+	}
+	return rules, nil
+}
+
+// redactionRuleConfig redacts the values found by either a built-in detector or a regex. The action is mask (the
+// default), hash, pseudonymize or drop. Hashed values can be recovered by brute force, pseudonymize is the action which
+// keeps values correlatable without exposing them.
+type redactionRuleConfig struct {
+	Name     string `config:"name"`
+	Detector string `config:"detector"`
+	Regex    string `config:"regex"`
+	Action   string `config:"action"`
+	Mask     string `config:"mask"`
+}
+
+func (rc *redactionRuleConfig) Validate() error {
+	if (rc.Detector == "") == (rc.Regex == "") {
+		return fmt.Errorf("invalid redaction rule. either detector or regex must be set")
+	}
+	if rc.Regex != "" && rc.Name == "" {
+		return fmt.Errorf("invalid redaction rule. name must be set for regex %v", rc.Regex)
+	}
+	switch rc.Action {
+	case "", processor.ActionMask, processor.ActionHash, processor.ActionPseudonymize, processor.ActionDrop:
+		return nil
+	default:
+		return fmt.Errorf("invalid redaction action %v. must be one of %v, %v, %v or %v", rc.Action,
+			processor.ActionMask, processor.ActionHash, processor.ActionPseudonymize, processor.ActionDrop)
+	}
+}
+
+func (rc *redactionRuleConfig) toRedactionRule(pseudonymizationKey string) (*processor.RedactionRule, error) {
+	if rc.Action == processor.ActionPseudonymize && pseudonymizationKey == "" {
+		return nil, fmt.Errorf("the pseudonymize action requires a pseudonymization key")
+	}
+	var detector *processor.Detector
+	var err error
+	if rc.Detector != "" {
+		detector, err = processor.NewDetector(rc.Detector)
+	} else {
+		detector, err = processor.NewRegexDetector(rc.Regex)
+	}
+	if err != nil {
+		return nil, err
+	}
+	rule := &processor.RedactionRule{
+		Name:     rc.Name,
+		Detector: detector,
+		Action:   rc.Action,
+		Mask:     rc.Mask,
+		Key:      []byte(pseudonymizationKey),
+	}
+	if rule.Name == "" {
+		rule.Name = rc.Detector
+	}
+	if rule.Action == "" {
+		rule.Action = processor.ActionMask
+	}
+	if rule.Mask == "" {
+		rule.Mask = processor.DefaultMask
+	}
+	return rule, nil
+}
+
+// dedupConfig suppresses logs which repeat a log of the same application, level and message within the window.
+// The repeats are collapsed into one log once the window has passed.
+type dedupConfig struct {
+	Enabled        bool          `config:"enabled"`
+	Window         time.Duration `config:"window"`
+	MaxEntries     int           `config:"max_entries" validate:"min=1"`
+	ApplicationTag string        `config:"application_tag"`
+}
+
+func (dc *dedupConfig) Validate() error {
+	if dc.Enabled && dc.Window <= 0 {
+		return fmt.Errorf("dedup.window must be greater than 0")
+	}
+	return nil
+}
+
+func (dc *dedupConfig) toDeduplicator() *processor.Deduplicator {
+	return processor.NewDeduplicator(dc.Window, dc.MaxEntries, dc.ApplicationTag)
+}
+
+// samplingConfig samples the logs whose levels are not in keep_levels. Either every n-th log per level is kept or
+// logs are kept with the probability rate. The logs of each application are limited to max_per_minute. Sampling is
+// disabled if none of them is set.
+type samplingConfig struct {
+	KeepLevels      []string      `config:"keep_levels"`
+	Rate            float64       `config:"rate" validate:"min=0, max=1"`
+	Every           int           `config:"every" validate:"min=0"`
+	MaxPerMinute    int           `config:"max_per_minute" validate:"min=0"`
+	ApplicationTag  string        `config:"application_tag"`
+	SummaryInterval time.Duration `config:"summary_interval" validate:"min=0"`
+}
+
+func (sc *samplingConfig) Validate() error {
+	if sc.Rate > 0 && sc.Every > 0 {
+		return fmt.Errorf("invalid sampling config. rate and every cannot both be set")
+	}
+	return nil
+}
+
+func (sc *samplingConfig) enabled() bool {
+	return sc.Rate > 0 || sc.Every > 0 || sc.MaxPerMinute > 0
+}
+
+func (sc *samplingConfig) toSampler() *processor.Sampler {
+	return processor.NewSampler(sc.KeepLevels, sc.Rate, sc.Every, sc.MaxPerMinute, sc.ApplicationTag,
+		sc.SummaryInterval)
+}
+
+// decodeJsonConfig configures the decoding of fields which contain a json object, e.g. the message of containers
+// which write json lines. The keys of the other mappers are resolved against the decoded fields.
+type decodeJsonConfig struct {
+	Enabled bool `config:"enabled"`
+	// Field defaults to the message key
+	Field         string `config:"field"`
+	Target        string `config:"target"`
+	OverwriteKeys bool   `config:"overwrite_keys"`
+}
+
+func (dc *decodeJsonConfig) toJsonDecoder(messageKey string) *mapper.JsonDecoder {
+	if !dc.Enabled {
+		return nil
+	}
+	field := dc.Field
+	if field == "" {
+		field = messageKey
+	}
+	return &mapper.JsonDecoder{Field: field, Target: dc.Target, OverwriteKeys: dc.OverwriteKeys}
+}
+
+// toFormatMapper returns the mapper of the configured format or nil if no format is configured
+func (mc *mappingConfig) toFormatMapper(source *mapper.StringMapper) (*mapper.FormatMapper, error) {
+	if mc.Format == "" {
+		if mc.FormatPattern != "" {
+			return nil, fmt.Errorf("format_pattern is set, but no format is configured")
+		}
+		return nil, nil
+	}
+	format, err := mapper.NewFormat(mc.Format, mc.FormatPattern)
+	if err != nil {
+		return nil, fmt.Errorf("%w; invalid format config", err)
+	}
+	return &mapper.FormatMapper{
+		Source:        *source,
+		Format:        format,
+		IgnoreFailure: mc.FormatIgnoreFailure,
+	}, nil
+}
+
 var (
 	defaultLogsightConfig = logsightConfig{
 		Url:          "",
 		Email:        "",
 		Password:     "",
-		MessageKey:   "message",
-		TimestampKey: "",
-		LevelKey:     "",
-		TagsMapping:  map[string]string{},
-		BatchSize:    100,
-		MaxRetries:   20,
-		Timeout:      120,
+		PasswordFile: "",
+		Auth: authConfig{
+			Mode:   AuthModePassword,
+			Scheme: api.DefaultTokenScheme,
+		},
+		Mapping: mappingConfig{
+			MessageKey:   []string{DefaultMessageKey},
+			TimestampKey: nil,
+			LevelKey:     nil,
+			TagsMapping:  map[string]string{},
+		},
+		Hints: hintsConfig{
+			Enabled: false,
+			Field:   mapper.DefaultHintsField,
+			Prefix:  mapper.DefaultHintsPrefix,
+		},
+		Dedup: dedupConfig{
+			Enabled:        false,
+			Window:         time.Minute,
+			MaxEntries:     10000,
+			ApplicationTag: mapper.ApplicationTag,
+		},
+		Sampling: samplingConfig{
+			KeepLevels:      processor.DefaultKeepLevels,
+			ApplicationTag:  mapper.ApplicationTag,
+			SummaryInterval: time.Minute,
+		},
+		Spool: spoolConfig{
+			Path:          "",
+			MaxSize:       100 * 1024 * 1024,
+			RetryInterval: 30 * time.Second,
+		},
+		BatchSize:  100,
+		MaxRetries: 20,
+		Timeout:    120,
+		ApiFormat:  api.FormatArray,
 	}
 )
@@ -1,6 +1,8 @@
 package plugin
 
 import (
+	"errors"
+	"fmt"
 	"github.com/elastic/beats/v7/libbeat/beat"
 	"github.com/elastic/beats/v7/libbeat/common"
 	"github.com/elastic/beats/v7/libbeat/common/transport/tlscommon"
@@ -28,17 +30,25 @@ func makeLogsight(
 	if err := cfg.Unpack(&config); err != nil {
 		return outputs.Fail(err)
 	}
+	if err := config.resolveCredentials(); err != nil {
+		logger.Errorf("failed to resolve credentials, Error: %v", err)
+		return outputs.Fail(err)
+	}
+	if err := config.resolvePseudonymizationKey(); err != nil {
+		logger.Errorf("failed to resolve pseudonymization key, Error: %v", err)
+		return outputs.Fail(err)
+	}
 	logger.Debugf("unpacked logsight config: %v", config.String())
 
 	proxyURL, err := parseProxyURL(config.ProxyURL)
 	if err != nil {
-		logger.Errorf("invalid url format for proxy: %v, Error: %v", proxyURL, err)
+		logger.Errorf("invalid url format for proxy: %v, Error: %v", redactURL(config.ProxyURL), err)
 		return outputs.Fail(err)
 	}
 
-	host := config.Url
+	host := redactURL(config.Url)
 	logger.Infof("Creating client for host: %v", host)
-	hostURL, err := url.Parse(host)
+	hostURL, err := parseURL(config.Url)
 	if err != nil {
 		logger.Errorf("invalid url format for host: %v, Error: %v", host, err)
 		return outputs.Fail(err)
@@ -46,10 +56,14 @@ func makeLogsight(
 
 	tlsConfig, err := tlscommon.LoadTLSConfig(config.TLS)
 	if err != nil {
-		logger.Errorf("failed to load tls config %v, Error: %v", config.TLS, err)
+		logger.Errorf("failed to load tls config, Error: %v", err)
 		return outputs.Fail(err)
 	}
-	logger.Debugf("TLS config: %v", tlsConfig)
+	if tlsConfig != nil {
+		// The certificates are not logged, since they contain the private key of the client certificate
+		logger.Debugf("TLS config: verification mode %v, versions %v, client certificates %v",
+			tlsConfig.Verification, tlsConfig.Versions, len(tlsConfig.Certificates))
+	}
 
 	var client outputs.NetworkClient
 	client, err = NewClient(config, hostURL, proxyURL, tlsConfig, observer, logger)
@@ -67,8 +81,18 @@ func parseProxyURL(raw string) (*url.URL, error) {
 	if raw == "" {
 		return nil, nil
 	}
+	return parseURL(raw)
+}
+
+// parseURL parses a url like url.Parse, but the error does not repeat the url because its userinfo may contain
+// credentials.
+func parseURL(raw string) (*url.URL, error) {
 	parsedUrl, err := url.Parse(raw)
 	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return nil, fmt.Errorf("%w; invalid url %v", urlErr.Err, redactURL(raw))
+		}
 		return nil, err
 	}
 	return parsedUrl, nil
@@ -0,0 +1,206 @@
+package mapper
+
+import (
+	"fmt"
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ParsedLine holds the fields which were parsed from a raw log line. Fields which are not part of a format stay
+// empty, so that the LogMapper can fall back to its other mappers.
+type ParsedLine struct {
+	Timestamp string
+	Level     string
+	Message   string
+	Tags      map[string]string
+}
+
+// LineFormat parses raw log lines of a specific format
+type LineFormat interface {
+	ParseLine(line string) (*ParsedLine, error)
+}
+
+// FormatMapper parses the value of the Source with a LineFormat. DoMap returns a *ParsedLine.
+type FormatMapper struct {
+	Source StringMapper
+	Format LineFormat
+	// IgnoreFailure makes the LogMapper use its other mappers for lines which do not match the format
+	IgnoreFailure bool
+}
+
+func (fm FormatMapper) DoMap(event beat.Event) (interface{}, error) {
+	line, err := fm.Source.doStringMap(event)
+	if err != nil {
+		return nil, err
+	}
+	return fm.Format.ParseLine(line)
+}
+
+func (fm *FormatMapper) doParse(event beat.Event) (*ParsedLine, error) {
+	parsed, err := fm.DoMap(event)
+	if err != nil {
+		return nil, err
+	}
+	return parsed.(*ParsedLine), nil
+}
+
+// Names of the built-in formats
+const (
+	FormatLogfmt         = "logfmt"
+	FormatJson           = "json"
+	FormatSyslogRFC3164  = "syslog_rfc3164"
+	FormatSyslogRFC5424  = "syslog_rfc5424"
+	FormatApacheCombined = "apache_combined"
+	FormatNginxError     = "nginx_error"
+	FormatLog4jPattern   = "log4j_pattern"
+	FormatPythonLogging  = "python_logging"
+	FormatKlog           = "klog"
+)
+
+var formatFactories = map[string]func(pattern string) (LineFormat, error){
+	FormatLogfmt:         func(string) (LineFormat, error) { return LogfmtFormat{}, nil },
+	FormatJson:           func(string) (LineFormat, error) { return JsonFormat{}, nil },
+	FormatSyslogRFC3164:  func(string) (LineFormat, error) { return SyslogRFC3164Format{}, nil },
+	FormatSyslogRFC5424:  func(string) (LineFormat, error) { return SyslogRFC5424Format{}, nil },
+	FormatApacheCombined: func(string) (LineFormat, error) { return ApacheCombinedFormat{}, nil },
+	FormatNginxError:     func(string) (LineFormat, error) { return NginxErrorFormat{}, nil },
+	FormatLog4jPattern:   func(pattern string) (LineFormat, error) { return NewLog4jPatternFormat(pattern) },
+	FormatPythonLogging:  func(pattern string) (LineFormat, error) { return NewPythonLoggingFormat(pattern) },
+	FormatKlog:           func(string) (LineFormat, error) { return KlogFormat{}, nil },
+}
+
+// NewFormat returns the built-in format with the name. The pattern is only used by formats which are configured by
+// a layout pattern (log4j_pattern and python_logging). An empty pattern selects their default layout.
+func NewFormat(name string, pattern string) (LineFormat, error) {
+	factory, ok := formatFactories[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown format %v. must be one of %v", name, strings.Join(FormatNames(), ", "))
+	}
+	return factory(pattern)
+}
+
+// FormatNames returns the sorted names of all built-in formats
+func FormatNames() []string {
+	names := make([]string, 0, len(formatFactories))
+	for name := range formatFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// levelAliases maps the level names of common log formats to the levels which are accepted by Logsight
+var levelAliases = map[string]string{
+	"TRACE":       "FINER",
+	"FINEST":      "FINER",
+	"FINER":       "FINER",
+	"FINE":        "FINE",
+	"DEBUG":       "DEBUG",
+	"DBG":         "DEBUG",
+	"INFO":        "INFO",
+	"INFORMATION": "INFO",
+	"NOTICE":      "INFO",
+	"WARN":        "WARN",
+	"WARNING":     "WARNING",
+	"ERR":         "ERR",
+	"ERROR":       "ERROR",
+	"EXCEPTION":   "EXCEPTION",
+	"SEVERE":      "SEVERE",
+	"CRIT":        "SEVERE",
+	"CRITICAL":    "SEVERE",
+	"ALERT":       "SEVERE",
+	"EMERG":       "SEVERE",
+	"EMERGENCY":   "SEVERE",
+	"FATAL":       "SEVERE",
+	"PANIC":       "SEVERE",
+}
+
+// normalizeLevel returns the Logsight level for a level name. Unknown names result in an empty string.
+func normalizeLevel(level string) string {
+	return levelAliases[strings.ToUpper(strings.TrimSpace(level))]
+}
+
+// syslogSeverities maps the syslog severity (the lower three bits of the priority) to a Logsight level
+var syslogSeverities = []string{"SEVERE", "SEVERE", "SEVERE", "ERROR", "WARNING", "INFO", "INFO", "DEBUG"}
+
+// formatTimestamp returns the time in the ISO 8601 format which is expected by the API
+func formatTimestamp(t time.Time) string {
+	return t.Format(time.RFC3339Nano)
+}
+
+// parseTimestamp parses the value with the first matching layout. Layouts without a time zone are interpreted in
+// the local time zone.
+func parseTimestamp(value string, layouts ...string) (string, bool) {
+	for _, layout := range layouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return formatTimestamp(t), true
+		}
+	}
+	return "", false
+}
+
+// withCurrentYear sets the year of timestamps which do not contain one. If this results in a time in the future,
+// the log is assumed to be from the previous year.
+func withCurrentYear(t time.Time, now time.Time) time.Time {
+	t = time.Date(now.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	if t.After(now.Add(24 * time.Hour)) {
+		t = t.AddDate(-1, 0, 0)
+	}
+	return t
+}
+
+// commonTimestampLayouts are tried for timestamps in structured formats like logfmt and json
+var commonTimestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02 15:04:05,999999999",
+	time.RFC1123Z,
+	time.RFC1123,
+}
+
+// Keys of structured formats which are mapped to the timestamp, level and message instead of tags
+var (
+	timestampKeys = []string{"@timestamp", "timestamp", "time", "ts", "datetime"}
+	levelKeys     = []string{"level", "lvl", "severity", "loglevel", "log.level"}
+	messageKeys   = []string{"message", "msg", "log"}
+)
+
+// fromStructuredFields builds a ParsedLine from key value pairs, e.g. of logfmt or json lines. The first of the
+// known timestamp, level and message keys is used, all other fields become tags.
+func fromStructuredFields(fields map[string]string) *ParsedLine {
+	parsed := &ParsedLine{Tags: make(map[string]string)}
+	used := make(map[string]bool)
+	for _, key := range timestampKeys {
+		if value, ok := fields[key]; ok {
+			if timestamp, ok := parseTimestamp(value, commonTimestampLayouts...); ok {
+				parsed.Timestamp = timestamp
+				used[key] = true
+				break
+			}
+		}
+	}
+	for _, key := range levelKeys {
+		if value, ok := fields[key]; ok {
+			parsed.Level = normalizeLevel(value)
+			used[key] = parsed.Level != ""
+			break
+		}
+	}
+	for _, key := range messageKeys {
+		if value, ok := fields[key]; ok {
+			parsed.Message = value
+			used[key] = true
+			break
+		}
+	}
+	for key, value := range fields {
+		if !used[key] {
+			parsed.Tags[key] = value
+		}
+	}
+	return parsed
+}
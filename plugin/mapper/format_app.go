@@ -0,0 +1,380 @@
+package mapper
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type patternFieldKind int
+
+const (
+	timestampField patternFieldKind = iota
+	levelField
+	messageField
+	tagField
+)
+
+// patternField describes a capture group of a patternFormat
+type patternField struct {
+	kind patternFieldKind
+	// tag is the name of the tag for tag fields
+	tag string
+	// layouts are the time layouts of timestamp fields. An empty list means unix time in seconds or milliseconds.
+	layouts []string
+}
+
+// patternFormat parses lines with a regular expression which was built from a logging library's layout pattern
+type patternFormat struct {
+	pattern string
+	expr    *regexp.Regexp
+	fields  []patternField
+}
+
+func (pf *patternFormat) ParseLine(line string) (*ParsedLine, error) {
+	matches := pf.expr.FindStringSubmatch(line)
+	if matches == nil {
+		return nil, fmt.Errorf("line of length %v does not match layout pattern %v", len(line), pf.pattern)
+	}
+	parsed := &ParsedLine{Tags: make(map[string]string)}
+	for i, field := range pf.fields {
+		value := matches[i+1]
+		if field.kind != messageField {
+			value = strings.TrimSpace(value)
+		}
+		if value == "" {
+			continue
+		}
+		switch field.kind {
+		case timestampField:
+			timestamp, err := parsePatternTimestamp(value, field.layouts)
+			if err != nil {
+				return nil, err
+			}
+			parsed.Timestamp = timestamp
+		case levelField:
+			parsed.Level = normalizeLevel(value)
+		case messageField:
+			parsed.Message = value
+		case tagField:
+			parsed.Tags[field.tag] = value
+		}
+	}
+	return parsed, nil
+}
+
+func parsePatternTimestamp(value string, layouts []string) (string, error) {
+	if len(layouts) == 0 {
+		if timestamp, ok := epochTimestamp(json.Number(value)); ok {
+			return timestamp, nil
+		}
+		return "", fmt.Errorf("invalid unix timestamp %v", value)
+	}
+	now := time.Now()
+	for _, layout := range layouts {
+		t, err := time.ParseInLocation(layout, value, time.Local)
+		if err != nil {
+			continue
+		}
+		switch {
+		case !strings.Contains(layout, "06") && !strings.Contains(layout, "Jan") && !strings.Contains(layout, "01"):
+			// Time of day only
+			t = time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+		case !strings.Contains(layout, "06"):
+			t = withCurrentYear(t, now)
+		}
+		return formatTimestamp(t), nil
+	}
+	return "", fmt.Errorf("timestamp %v does not match layouts %v", value, layouts)
+}
+
+// patternBuilder collects the regular expression and the fields of a patternFormat
+type patternBuilder struct {
+	expr   strings.Builder
+	fields []patternField
+}
+
+func (pb *patternBuilder) literal(value string) {
+	pb.expr.WriteString(regexp.QuoteMeta(value))
+}
+
+func (pb *patternBuilder) field(field patternField) {
+	switch field.kind {
+	case messageField:
+		pb.expr.WriteString(`(.*)`)
+	case levelField:
+		pb.expr.WriteString(`\s*([A-Za-z]+)\s*`)
+	default:
+		pb.expr.WriteString(`(.*?)`)
+	}
+	pb.fields = append(pb.fields, field)
+}
+
+func (pb *patternBuilder) build(pattern string) (*patternFormat, error) {
+	expr, err := regexp.Compile("^" + pb.expr.String() + "$")
+	if err != nil {
+		return nil, fmt.Errorf("%w; failed to compile layout pattern %v", err, pattern)
+	}
+	return &patternFormat{pattern: pattern, expr: expr, fields: pb.fields}, nil
+}
+
+// DefaultLog4jPattern is used by the log4j_pattern format if no pattern is configured
+const DefaultLog4jPattern = "%d [%t] %-5p %c - %m%n"
+
+// Log4jPatternFormat parses lines which were written with a log4j or logback PatternLayout, e.g. %d [%t] %-5p %c - %m%n
+type Log4jPatternFormat struct {
+	*patternFormat
+}
+
+var (
+	log4jConversionRegex = regexp.MustCompile(`^%[-.\d]*([a-zA-Z]+)(?:\{([^}]*)\})?(?:\{([^}]*)\})?`)
+	log4jTags            = map[string]string{
+		"t": "thread", "thread": "thread", "tn": "thread",
+		"c": "logger", "lo": "logger", "logger": "logger",
+		"C": "class", "class": "class",
+		"M": "method", "method": "method",
+		"L": "line", "line": "line",
+		"F": "file", "file": "file",
+		"x": "ndc", "NDC": "ndc",
+		"r": "elapsed", "relative": "elapsed",
+		"pid": "pid", "processId": "pid",
+	}
+	log4jNamedDateFormats = map[string]string{
+		"DEFAULT":  "yyyy-MM-dd HH:mm:ss,SSS",
+		"ISO8601":  "yyyy-MM-dd'T'HH:mm:ss,SSS",
+		"ABSOLUTE": "HH:mm:ss,SSS",
+		"DATE":     "dd MMM yyyy HH:mm:ss,SSS",
+	}
+)
+
+func NewLog4jPatternFormat(pattern string) (*Log4jPatternFormat, error) {
+	if pattern == "" {
+		pattern = DefaultLog4jPattern
+	}
+	var builder patternBuilder
+	rest := pattern
+	for rest != "" {
+		percent := strings.IndexByte(rest, '%')
+		if percent < 0 {
+			builder.literal(rest)
+			break
+		}
+		builder.literal(rest[:percent])
+		rest = rest[percent:]
+		if strings.HasPrefix(rest, "%%") {
+			builder.literal("%")
+			rest = rest[2:]
+			continue
+		}
+		matches := log4jConversionRegex.FindStringSubmatch(rest)
+		if matches == nil {
+			return nil, fmt.Errorf("invalid conversion at %v in log4j pattern %v", rest, pattern)
+		}
+		rest = rest[len(matches[0]):]
+		conversion, option := matches[1], matches[2]
+		switch conversion {
+		case "n":
+			continue
+		case "d", "date":
+			if matches[3] != "" {
+				return nil, fmt.Errorf("time zone option %v of %%%v is not supported in log4j pattern %v",
+					matches[3], conversion, pattern)
+			}
+			layouts, err := log4jDateLayouts(option)
+			if err != nil {
+				return nil, fmt.Errorf("%w; in log4j pattern %v", err, pattern)
+			}
+			builder.field(patternField{kind: timestampField, layouts: layouts})
+		case "p", "level", "le":
+			builder.field(patternField{kind: levelField})
+		case "m", "msg", "message":
+			builder.field(patternField{kind: messageField})
+		case "X", "mdc", "MDC":
+			tag := "mdc"
+			if option != "" {
+				tag = option
+			}
+			builder.field(patternField{kind: tagField, tag: tag})
+		default:
+			tag, ok := log4jTags[conversion]
+			if !ok {
+				return nil, fmt.Errorf("unsupported conversion %%%v in log4j pattern %v", conversion, pattern)
+			}
+			builder.field(patternField{kind: tagField, tag: tag})
+		}
+	}
+	format, err := builder.build(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &Log4jPatternFormat{patternFormat: format}, nil
+}
+
+// log4jDateLayouts converts the option of a %d conversion, e.g. {yyyy-MM-dd HH:mm:ss.SSS}, to time layouts
+func log4jDateLayouts(option string) ([]string, error) {
+	switch option {
+	case "":
+		option = log4jNamedDateFormats["DEFAULT"]
+	case "UNIX", "UNIX_MILLIS":
+		return nil, nil
+	}
+	if named, ok := log4jNamedDateFormats[option]; ok {
+		option = named
+	}
+	layout, err := javaDateLayout(option)
+	if err != nil {
+		return nil, err
+	}
+	return []string{layout}, nil
+}
+
+var javaDateLayoutElements = map[string]string{
+	"yyyy": "2006", "yy": "06", "MMMM": "January", "MMM": "Jan", "MM": "01", "M": "1", "dd": "02", "d": "2",
+	"HH": "15", "H": "15", "hh": "03", "h": "3", "mm": "04", "m": "4", "ss": "05", "s": "5", "a": "PM",
+	"EEEE": "Monday", "EEE": "Mon", "Z": "-0700", "X": "-07", "XX": "-0700", "XXX": "-07:00", "z": "MST",
+}
+
+// javaDateLayout converts a java SimpleDateFormat pattern to a time layout
+func javaDateLayout(format string) (string, error) {
+	var layout strings.Builder
+	for i := 0; i < len(format); {
+		c := format[i]
+		switch {
+		case c == '\'':
+			end := strings.IndexByte(format[i+1:], '\'')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated quote in date format %v", format)
+			}
+			if end == 0 {
+				layout.WriteByte('\'')
+			}
+			layout.WriteString(format[i+1 : i+1+end])
+			i += end + 2
+		case c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			j := i
+			for j < len(format) && format[j] == c {
+				j++
+			}
+			element := format[i:j]
+			if c == 'S' {
+				// Fractional seconds are only supported after a separator, e.g. ss,SSS
+				if i == 0 || (format[i-1] != '.' && format[i-1] != ',') {
+					return "", fmt.Errorf("unsupported fractional seconds without separator in date format %v", format)
+				}
+				layout.WriteString(strings.Repeat("0", len(element)))
+			} else if converted, ok := javaDateLayoutElements[element]; ok {
+				layout.WriteString(converted)
+			} else {
+				return "", fmt.Errorf("unsupported element %v in date format %v", element, format)
+			}
+			i = j
+		default:
+			layout.WriteByte(c)
+			i++
+		}
+	}
+	return layout.String(), nil
+}
+
+// DefaultPythonLoggingPattern is used by the python_logging format if no pattern is configured
+const DefaultPythonLoggingPattern = "%(asctime)s - %(name)s - %(levelname)s - %(message)s"
+
+// PythonLoggingFormat parses lines which were written by a python logging.Formatter, e.g.
+// %(asctime)s - %(name)s - %(levelname)s - %(message)s
+type PythonLoggingFormat struct {
+	*patternFormat
+}
+
+var (
+	pythonAttributeRegex = regexp.MustCompile(`^%\((\w+)\)[-#0 +]*\d*(?:\.\d+)?[sdfr]`)
+	pythonTimeLayouts    = append([]string{"2006-01-02 15:04:05,000"}, commonTimestampLayouts...)
+)
+
+func NewPythonLoggingFormat(pattern string) (*PythonLoggingFormat, error) {
+	if pattern == "" {
+		pattern = DefaultPythonLoggingPattern
+	}
+	var builder patternBuilder
+	rest := pattern
+	for rest != "" {
+		percent := strings.IndexByte(rest, '%')
+		if percent < 0 {
+			builder.literal(rest)
+			break
+		}
+		builder.literal(rest[:percent])
+		rest = rest[percent:]
+		if strings.HasPrefix(rest, "%%") {
+			builder.literal("%")
+			rest = rest[2:]
+			continue
+		}
+		matches := pythonAttributeRegex.FindStringSubmatch(rest)
+		if matches == nil {
+			return nil, fmt.Errorf("invalid attribute at %v in python logging pattern %v", rest, pattern)
+		}
+		rest = rest[len(matches[0]):]
+		switch attribute := matches[1]; attribute {
+		case "asctime":
+			builder.field(patternField{kind: timestampField, layouts: pythonTimeLayouts})
+		case "created":
+			builder.field(patternField{kind: timestampField})
+		case "levelname":
+			builder.field(patternField{kind: levelField})
+		case "message":
+			builder.field(patternField{kind: messageField})
+		case "name":
+			builder.field(patternField{kind: tagField, tag: "logger"})
+		default:
+			builder.field(patternField{kind: tagField, tag: attribute})
+		}
+	}
+	format, err := builder.build(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &PythonLoggingFormat{patternFormat: format}, nil
+}
+
+// KlogFormat parses the log lines of Kubernetes components, e.g.
+// I0404 09:00:35.123456   12345 controller.go:123] "Pod status updated" pod="kube-system/dns"
+// The key value pairs of structured klog messages become tags.
+type KlogFormat struct{}
+
+var (
+	klogRegex  = regexp.MustCompile(`^([IWEF])(\d{4} \d{2}:\d{2}:\d{2}\.\d{6})\s+(\d+) ([^:\]]+):(\d+)\] (.*)$`)
+	klogLevels = map[string]string{"I": "INFO", "W": "WARNING", "E": "ERROR", "F": "SEVERE"}
+)
+
+func (KlogFormat) ParseLine(line string) (*ParsedLine, error) {
+	matches := klogRegex.FindStringSubmatch(line)
+	if matches == nil {
+		return nil, fmt.Errorf("line of length %v is not in klog format", len(line))
+	}
+	t, err := time.ParseInLocation("0102 15:04:05.000000", matches[2], time.Local)
+	if err != nil {
+		return nil, fmt.Errorf("%w; invalid klog timestamp %v", err, matches[2])
+	}
+	parsed := &ParsedLine{
+		Timestamp: formatTimestamp(withCurrentYear(t, time.Now())),
+		Level:     klogLevels[matches[1]],
+		Message:   matches[6],
+		Tags:      map[string]string{"thread_id": matches[3], "file": matches[4], "line": matches[5]},
+	}
+
+	if quoted, err := strconv.QuotedPrefix(parsed.Message); err == nil {
+		fields, err := parseLogfmt(parsed.Message[len(quoted):])
+		if err == nil {
+			parsed.Message, _ = strconv.Unquote(quoted)
+			for key, value := range fields {
+				if _, exists := parsed.Tags[key]; !exists {
+					parsed.Tags[key] = value
+				}
+			}
+		}
+	}
+	return parsed, nil
+}
@@ -0,0 +1,123 @@
+package mapper
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LogfmtFormat parses lines of key=value pairs, e.g. time=2022-04-04T09:00:35Z level=info msg="user logged in"
+type LogfmtFormat struct{}
+
+func (LogfmtFormat) ParseLine(line string) (*ParsedLine, error) {
+	fields, err := parseLogfmt(line)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("no logfmt fields found in line of length %v", len(line))
+	}
+	return fromStructuredFields(fields), nil
+}
+
+// parseLogfmt returns the key value pairs of a logfmt string. Values can be quoted with escape sequences like in Go
+// strings. Keys without a value get an empty value.
+func parseLogfmt(line string) (map[string]string, error) {
+	fields := make(map[string]string)
+	rest := strings.TrimSpace(line)
+	for rest != "" {
+		end := strings.IndexAny(rest, "= ")
+		if end == 0 {
+			return nil, fmt.Errorf("invalid logfmt line of length %v: empty key", len(line))
+		}
+		if end < 0 || rest[end] == ' ' {
+			if end < 0 {
+				end = len(rest)
+			}
+			fields[rest[:end]] = ""
+			rest = strings.TrimSpace(rest[end:])
+			continue
+		}
+		key := rest[:end]
+		rest = rest[end+1:]
+		var value string
+		if strings.HasPrefix(rest, "\"") {
+			quoted, err := strconv.QuotedPrefix(rest)
+			if err != nil {
+				return nil, fmt.Errorf("%w; invalid quoted value of key %v in logfmt line of length %v", err, key, len(line))
+			}
+			value, _ = strconv.Unquote(quoted)
+			rest = rest[len(quoted):]
+		} else {
+			end = strings.IndexByte(rest, ' ')
+			if end < 0 {
+				end = len(rest)
+			}
+			value = rest[:end]
+			rest = rest[end:]
+		}
+		fields[key] = value
+		rest = strings.TrimSpace(rest)
+	}
+	return fields, nil
+}
+
+// JsonFormat parses lines with a json object. Nested objects are flattened to tags with dotted keys.
+type JsonFormat struct{}
+
+func (JsonFormat) ParseLine(line string) (*ParsedLine, error) {
+	var object map[string]interface{}
+	decoder := json.NewDecoder(strings.NewReader(line))
+	decoder.UseNumber()
+	if err := decoder.Decode(&object); err != nil {
+		return nil, fmt.Errorf("%w; line of length %v is not a json object", err, len(line))
+	}
+	fields := make(map[string]string)
+	flattenJson("", object, fields)
+
+	// Epoch timestamps are not covered by the layouts of the structured formats
+	for _, key := range timestampKeys {
+		if number, ok := object[key].(json.Number); ok {
+			if timestamp, ok := epochTimestamp(number); ok {
+				fields[key] = timestamp
+			}
+			break
+		}
+	}
+	return fromStructuredFields(fields), nil
+}
+
+func flattenJson(prefix string, object map[string]interface{}, fields map[string]string) {
+	for key, value := range object {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			flattenJson(prefix+key+".", v, fields)
+		case string:
+			fields[prefix+key] = v
+		case nil:
+			continue
+		case json.Number, bool:
+			fields[prefix+key] = fmt.Sprintf("%v", v)
+		default:
+			encoded, _ := json.Marshal(v)
+			fields[prefix+key] = string(encoded)
+		}
+	}
+}
+
+// epochTimestamp converts unix timestamps in seconds or milliseconds
+func epochTimestamp(number json.Number) (string, bool) {
+	value, err := number.Float64()
+	if err != nil || value <= 0 {
+		return "", false
+	}
+	// Timestamps in seconds are far below 1e11 for the next thousands of years
+	if value > 1e11 {
+		value /= 1000
+	}
+	seconds := int64(value)
+	nanos := int64((value - float64(seconds)) * 1e9)
+	return formatTimestamp(time.Unix(seconds, nanos/1e6*1e6)), true
+}
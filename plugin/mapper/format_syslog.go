@@ -0,0 +1,140 @@
+package mapper
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var syslogFacilities = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news", "uucp", "cron", "authpriv", "ftp", "ntp",
+	"security", "console", "solaris-cron", "local0", "local1", "local2", "local3", "local4", "local5", "local6",
+	"local7",
+}
+
+// parsePriority sets the level and the facility tag of a syslog priority value, e.g. 34 of <34>
+func parsePriority(priority string, parsed *ParsedLine) error {
+	value, err := strconv.Atoi(priority)
+	if err != nil || value < 0 || value > 191 {
+		return fmt.Errorf("invalid syslog priority %v", priority)
+	}
+	parsed.Level = syslogSeverities[value%8]
+	parsed.Tags["facility"] = syslogFacilities[value/8]
+	return nil
+}
+
+// SyslogRFC3164Format parses BSD syslog lines, e.g. <34>Oct 11 22:14:15 mymachine su[123]: 'su root' failed.
+// The priority is optional, since many local syslog daemons do not write it to files.
+type SyslogRFC3164Format struct{}
+
+var syslogRFC3164Regex = regexp.MustCompile(
+	`^(?:<(\d{1,3})>)?([A-Z][a-z]{2} [ \d]\d \d{2}:\d{2}:\d{2}) (\S+) ([^:\[\s]+)(?:\[([^\]]*)\])?: ?(.*)$`)
+
+func (SyslogRFC3164Format) ParseLine(line string) (*ParsedLine, error) {
+	matches := syslogRFC3164Regex.FindStringSubmatch(line)
+	if matches == nil {
+		return nil, fmt.Errorf("line of length %v is not in syslog rfc3164 format", len(line))
+	}
+	parsed := &ParsedLine{Message: matches[6], Tags: map[string]string{"hostname": matches[3], "app": matches[4]}}
+	if matches[1] != "" {
+		if err := parsePriority(matches[1], parsed); err != nil {
+			return nil, err
+		}
+	}
+	if matches[5] != "" {
+		parsed.Tags["pid"] = matches[5]
+	}
+	t, err := time.ParseInLocation(time.Stamp, matches[2], time.Local)
+	if err != nil {
+		return nil, fmt.Errorf("%w; invalid syslog timestamp %v", err, matches[2])
+	}
+	parsed.Timestamp = formatTimestamp(withCurrentYear(t, time.Now()))
+	return parsed, nil
+}
+
+// SyslogRFC5424Format parses syslog lines of the IETF format, e.g.
+// <165>1 2003-10-11T22:14:15.003Z mymachine evntslog 123 ID47 [exampleSDID@32473 iut="3"] An application event.
+// Structured data parameters become tags named <sd-id>.<param-name>.
+type SyslogRFC5424Format struct{}
+
+var syslogRFC5424Regex = regexp.MustCompile(`^<(\d{1,3})>1 (\S+) (\S+) (\S+) (\S+) (\S+) (.*)$`)
+
+func (SyslogRFC5424Format) ParseLine(line string) (*ParsedLine, error) {
+	matches := syslogRFC5424Regex.FindStringSubmatch(line)
+	if matches == nil {
+		return nil, fmt.Errorf("line of length %v is not in syslog rfc5424 format", len(line))
+	}
+	parsed := &ParsedLine{Tags: make(map[string]string)}
+	if err := parsePriority(matches[1], parsed); err != nil {
+		return nil, err
+	}
+	if matches[2] != "-" {
+		timestamp, ok := parseTimestamp(matches[2], time.RFC3339Nano)
+		if !ok {
+			return nil, fmt.Errorf("invalid syslog timestamp %v", matches[2])
+		}
+		parsed.Timestamp = timestamp
+	}
+	for i, name := range []string{"hostname", "app", "pid", "msgid"} {
+		if value := matches[3+i]; value != "-" {
+			parsed.Tags[name] = value
+		}
+	}
+	message, err := parseStructuredData(matches[7], parsed.Tags)
+	if err != nil {
+		return nil, fmt.Errorf("%w; in line of length %v", err, len(line))
+	}
+	parsed.Message = strings.TrimPrefix(message, "\ufeff")
+	return parsed, nil
+}
+
+// parseStructuredData adds the parameters of the structured data elements to the tags and returns the message
+// which follows them
+func parseStructuredData(rest string, tags map[string]string) (string, error) {
+	if strings.HasPrefix(rest, "-") {
+		return strings.TrimPrefix(strings.TrimPrefix(rest, "-"), " "), nil
+	}
+	for strings.HasPrefix(rest, "[") {
+		end := strings.IndexAny(rest, " ]")
+		if end < 0 {
+			return "", fmt.Errorf("unterminated structured data element")
+		}
+		id := rest[1:end]
+		rest = rest[end:]
+		for strings.HasPrefix(rest, " ") {
+			rest = strings.TrimLeft(rest, " ")
+			eq := strings.Index(rest, "=\"")
+			if eq < 0 {
+				return "", fmt.Errorf("invalid structured data parameter in element %v", id)
+			}
+			name := rest[:eq]
+			rest = rest[eq+2:]
+			var value strings.Builder
+			closed := false
+			for i := 0; i < len(rest); i++ {
+				if rest[i] == '\\' && i+1 < len(rest) {
+					i++
+					value.WriteByte(rest[i])
+					continue
+				}
+				if rest[i] == '"' {
+					rest = rest[i+1:]
+					closed = true
+					break
+				}
+				value.WriteByte(rest[i])
+			}
+			if !closed {
+				return "", fmt.Errorf("unterminated value of structured data parameter %v", name)
+			}
+			tags[id+"."+name] = value.String()
+		}
+		if !strings.HasPrefix(rest, "]") {
+			return "", fmt.Errorf("unterminated structured data element %v", id)
+		}
+		rest = rest[1:]
+	}
+	return strings.TrimPrefix(rest, " "), nil
+}
@@ -0,0 +1,408 @@
+package mapper
+
+import (
+	"github.com/aiops/logsight-filebeat/plugin/api"
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/common"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// localTimestamp returns the expected timestamp of a time without zone, which is interpreted as local time
+func localTimestamp(layout string, value string) string {
+	t, _ := time.ParseInLocation(layout, value, time.Local)
+	if t.Year() == 0 {
+		t = withCurrentYear(t, time.Now())
+	}
+	return formatTimestamp(t)
+}
+
+func TestNewFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		pattern string
+		wantErr bool
+	}{
+		{name: "pass logfmt", format: FormatLogfmt, wantErr: false},
+		{name: "pass log4j default pattern", format: FormatLog4jPattern, wantErr: false},
+		{name: "pass log4j pattern", format: FormatLog4jPattern, pattern: "%d{HH:mm:ss.SSS} %-5level %logger{36} - %msg%n", wantErr: false},
+		{name: "pass python pattern", format: FormatPythonLogging, pattern: "%(levelname)s:%(name)s:%(message)s", wantErr: false},
+		{name: "fail unknown format", format: "bogus", wantErr: true},
+		{name: "fail unsupported log4j conversion", format: FormatLog4jPattern, pattern: "%d %highlight{%p} %m", wantErr: true},
+		{name: "fail log4j time zone option", format: FormatLog4jPattern, pattern: "%d{HH:mm:ss}{UTC} %m", wantErr: true},
+		{name: "fail log4j fraction without separator", format: FormatLog4jPattern, pattern: "%d{HHmmssSSS} %m", wantErr: true},
+		{name: "fail invalid python attribute", format: FormatPythonLogging, pattern: "%(message)x", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewFormat(tt.format, tt.pattern); (err != nil) != tt.wantErr {
+				t.Errorf("NewFormat() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLineFormat_ParseLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		pattern string
+		line    string
+		want    *ParsedLine
+		wantErr bool
+	}{
+		{
+			name:   "pass logfmt",
+			format: FormatLogfmt,
+			line:   `time=2022-04-04T09:00:35.123Z level=warn msg="disk \"data\" almost full" used=91% readonly`,
+			want: &ParsedLine{
+				Timestamp: "2022-04-04T09:00:35.123Z",
+				Level:     "WARN",
+				Message:   `disk "data" almost full`,
+				Tags:      map[string]string{"used": "91%", "readonly": ""},
+			},
+			wantErr: false,
+		},
+		{
+			name:    "fail logfmt unterminated quote",
+			format:  FormatLogfmt,
+			line:    `level=info msg="unterminated`,
+			wantErr: true,
+		},
+		{
+			name:   "pass json",
+			format: FormatJson,
+			line:   `{"ts":1649062835.5,"severity":"ERROR","msg":"failed","http":{"status":500},"retry":false,"ids":[1,2]}`,
+			want: &ParsedLine{
+				Timestamp: formatTimestamp(time.UnixMilli(1649062835500)),
+				Level:     "ERROR",
+				Message:   "failed",
+				Tags:      map[string]string{"http.status": "500", "retry": "false", "ids": "[1,2]"},
+			},
+			wantErr: false,
+		},
+		{
+			name:   "pass json unknown level is kept as tag",
+			format: FormatJson,
+			line:   `{"level":"verbose","message":"hello"}`,
+			want:   &ParsedLine{Message: "hello", Tags: map[string]string{"level": "verbose"}},
+		},
+		{
+			name:    "fail json array",
+			format:  FormatJson,
+			line:    `["not", "an", "object"]`,
+			wantErr: true,
+		},
+		{
+			name:   "pass syslog rfc3164",
+			format: FormatSyslogRFC3164,
+			line:   "<34>Oct 11 22:14:15 mymachine su[123]: 'su root' failed for lonvick on /dev/pts/8",
+			want: &ParsedLine{
+				Timestamp: localTimestamp(time.Stamp, "Oct 11 22:14:15"),
+				Level:     "SEVERE",
+				Message:   "'su root' failed for lonvick on /dev/pts/8",
+				Tags:      map[string]string{"hostname": "mymachine", "app": "su", "pid": "123", "facility": "auth"},
+			},
+			wantErr: false,
+		},
+		{
+			name:   "pass syslog rfc3164 without priority",
+			format: FormatSyslogRFC3164,
+			line:   "Apr  4 09:00:35 web-1 systemd: Started Session 42.",
+			want: &ParsedLine{
+				Timestamp: localTimestamp(time.Stamp, "Apr  4 09:00:35"),
+				Message:   "Started Session 42.",
+				Tags:      map[string]string{"hostname": "web-1", "app": "systemd"},
+			},
+			wantErr: false,
+		},
+		{
+			name:   "pass syslog rfc5424",
+			format: FormatSyslogRFC5424,
+			line:   `<165>1 2003-10-11T22:14:15.003Z mymachine evntslog - ID47 [exampleSDID@32473 iut="3" eventSource="App\"lication"] An application event`,
+			want: &ParsedLine{
+				Timestamp: "2003-10-11T22:14:15.003Z",
+				Level:     "INFO",
+				Message:   "An application event",
+				Tags: map[string]string{
+					"hostname":                      "mymachine",
+					"app":                           "evntslog",
+					"msgid":                         "ID47",
+					"facility":                      "local4",
+					"exampleSDID@32473.iut":         "3",
+					"exampleSDID@32473.eventSource": `App"lication`,
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name:    "fail syslog rfc5424 unterminated structured data",
+			format:  FormatSyslogRFC5424,
+			line:    `<165>1 2003-10-11T22:14:15.003Z mymachine evntslog - ID47 [exampleSDID@32473 iut="3" message`,
+			wantErr: true,
+		},
+		{
+			name:   "pass apache combined",
+			format: FormatApacheCombined,
+			line:   `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 404 2326 "http://www.example.com/start.html" "Mozilla/4.08"`,
+			want: &ParsedLine{
+				Timestamp: "2000-10-10T13:55:36-07:00",
+				Level:     "WARNING",
+				Message:   "GET /apache_pb.gif HTTP/1.0",
+				Tags: map[string]string{
+					"client_ip":  "127.0.0.1",
+					"user":       "frank",
+					"method":     "GET",
+					"path":       "/apache_pb.gif",
+					"protocol":   "HTTP/1.0",
+					"status":     "404",
+					"bytes":      "2326",
+					"referrer":   "http://www.example.com/start.html",
+					"user_agent": "Mozilla/4.08",
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name:    "fail apache common without status",
+			format:  FormatApacheCombined,
+			line:    `127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET / HTTP/1.0"`,
+			wantErr: true,
+		},
+		{
+			name:   "pass nginx error",
+			format: FormatNginxError,
+			line:   `2022/04/04 09:00:35 [error] 1234#5678: *9 open() "/var/www/favicon.ico" failed (2: No such file or directory), client: 10.0.0.1, server: example.com, request: "GET /favicon.ico HTTP/1.1", host: "example.com"`,
+			want: &ParsedLine{
+				Timestamp: localTimestamp("2006/01/02 15:04:05", "2022/04/04 09:00:35"),
+				Level:     "ERROR",
+				Message:   `open() "/var/www/favicon.ico" failed (2: No such file or directory)`,
+				Tags: map[string]string{
+					"pid":        "1234",
+					"tid":        "5678",
+					"connection": "9",
+					"client":     "10.0.0.1",
+					"server":     "example.com",
+					"request":    "GET /favicon.ico HTTP/1.1",
+					"host":       "example.com",
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name:   "pass log4j default pattern",
+			format: FormatLog4jPattern,
+			line:   "2022-04-04 09:00:35,123 [main] WARN  com.example.App - connection lost",
+			want: &ParsedLine{
+				Timestamp: localTimestamp("2006-01-02 15:04:05,000", "2022-04-04 09:00:35,123"),
+				Level:     "WARN",
+				Message:   "connection lost",
+				Tags:      map[string]string{"thread": "main", "logger": "com.example.App"},
+			},
+			wantErr: false,
+		},
+		{
+			name:    "pass log4j custom pattern",
+			format:  FormatLog4jPattern,
+			pattern: "%d{yyyy-MM-dd'T'HH:mm:ss.SSSXXX} %5p %X{requestId} %c{1}:%L - %m%n",
+			line:    "2022-04-04T09:00:35.123+02:00 ERROR 42af App:17 - request failed",
+			want: &ParsedLine{
+				Timestamp: "2022-04-04T09:00:35.123+02:00",
+				Level:     "ERROR",
+				Message:   "request failed",
+				Tags:      map[string]string{"requestId": "42af", "logger": "App", "line": "17"},
+			},
+			wantErr: false,
+		},
+		{
+			name:    "fail log4j line does not match",
+			format:  FormatLog4jPattern,
+			line:    "connection lost",
+			wantErr: true,
+		},
+		{
+			name:   "pass python default pattern",
+			format: FormatPythonLogging,
+			line:   "2022-04-04 09:00:35,123 - app.db - CRITICAL - database unavailable",
+			want: &ParsedLine{
+				Timestamp: localTimestamp("2006-01-02 15:04:05,000", "2022-04-04 09:00:35,123"),
+				Level:     "SEVERE",
+				Message:   "database unavailable",
+				Tags:      map[string]string{"logger": "app.db"},
+			},
+			wantErr: false,
+		},
+		{
+			name:    "pass python custom pattern",
+			format:  FormatPythonLogging,
+			pattern: "[%(levelname)-8s] %(module)s:%(lineno)d %(message)s",
+			line:    "[INFO    ] views:42 user logged in",
+			want: &ParsedLine{
+				Level:   "INFO",
+				Message: "user logged in",
+				Tags:    map[string]string{"module": "views", "lineno": "42"},
+			},
+			wantErr: false,
+		},
+		{
+			name:   "pass klog",
+			format: FormatKlog,
+			line:   "E0404 09:00:35.123456   12345 controller.go:123] failed to sync pod",
+			want: &ParsedLine{
+				Timestamp: localTimestamp("0102 15:04:05.000000", "0404 09:00:35.123456"),
+				Level:     "ERROR",
+				Message:   "failed to sync pod",
+				Tags:      map[string]string{"thread_id": "12345", "file": "controller.go", "line": "123"},
+			},
+			wantErr: false,
+		},
+		{
+			name:   "pass klog structured",
+			format: FormatKlog,
+			line:   `I0404 09:00:35.123456       1 status.go:71] "Pod status updated" pod="kube-system/dns" status="ready"`,
+			want: &ParsedLine{
+				Timestamp: localTimestamp("0102 15:04:05.000000", "0404 09:00:35.123456"),
+				Level:     "INFO",
+				Message:   "Pod status updated",
+				Tags: map[string]string{
+					"thread_id": "1",
+					"file":      "status.go",
+					"line":      "71",
+					"pod":       "kube-system/dns",
+					"status":    "ready",
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name:    "fail klog",
+			format:  FormatKlog,
+			line:    "I0404 failed to sync pod",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			format, err := NewFormat(tt.format, tt.pattern)
+			if err != nil {
+				t.Fatalf("NewFormat() error = %v", err)
+			}
+			got, err := format.ParseLine(tt.line)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseLine() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				assert.NotContains(t, err.Error(), tt.line)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_withCurrentYear(t *testing.T) {
+	now := time.Date(2022, 1, 1, 0, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		t    time.Time
+		want time.Time
+	}{
+		{
+			name: "pass current year",
+			t:    time.Date(0, 1, 1, 0, 10, 0, 0, time.UTC),
+			want: time.Date(2022, 1, 1, 0, 10, 0, 0, time.UTC),
+		},
+		{
+			name: "pass previous year",
+			t:    time.Date(0, 12, 31, 23, 50, 0, 0, time.UTC),
+			want: time.Date(2021, 12, 31, 23, 50, 0, 0, time.UTC),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, withCurrentYear(tt.t, now))
+		})
+	}
+}
+
+func TestLogMapper_ToLog_format(t *testing.T) {
+	newLogMapper := func(ignoreFailure bool) *LogMapper {
+		return &LogMapper{
+			TimestampMapper: &StringMapper{Mapper: &ConstantStringMapper{ConstantString: "2022-04-01T20:10:57+02:00"}},
+			MessageMapper:   &StringMapper{Mapper: KeyMapper{Key: "message"}},
+			LevelMapper:     &StringMapper{Mapper: &ConstantStringMapper{ConstantString: "INFO"}},
+			TagsMapper: &MultipleKeyValueStringMapper{
+				Mapper: MultipleKeyValueMapper{map[string]string{"service": "service.name"}},
+			},
+			FormatMapper: &FormatMapper{
+				Source:        StringMapper{Mapper: KeyMapper{Key: "message"}},
+				Format:        LogfmtFormat{},
+				IgnoreFailure: ignoreFailure,
+			},
+		}
+	}
+	tests := []struct {
+		name          string
+		ignoreFailure bool
+		message       string
+		want          *api.Log
+		wantErr       bool
+	}{
+		{
+			name:    "pass format fields take precedence",
+			message: `ts=2022-04-04T09:00:35Z level=error msg="request failed" service=other status=500`,
+			want: &api.Log{
+				Timestamp: "2022-04-04T09:00:35Z",
+				Message:   "request failed",
+				Level:     "ERROR",
+				Tags:      map[string]string{"service": "checkout", "status": "500"},
+			},
+			wantErr: false,
+		},
+		{
+			name:    "pass missing fields fall back to mappers",
+			message: `status=200`,
+			want: &api.Log{
+				Timestamp: "2022-04-01T20:10:57+02:00",
+				Message:   "status=200",
+				Level:     "INFO",
+				Tags:      map[string]string{"service": "checkout", "status": "200"},
+			},
+			wantErr: false,
+		},
+		{
+			name:          "pass ignore failure",
+			ignoreFailure: true,
+			message:       `msg="unterminated`,
+			want: &api.Log{
+				Timestamp: "2022-04-01T20:10:57+02:00",
+				Message:   `msg="unterminated`,
+				Level:     "INFO",
+				Tags:      map[string]string{"service": "checkout"},
+			},
+			wantErr: false,
+		},
+		{
+			name:    "fail format does not match",
+			message: `msg="unterminated`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := beat.Event{Fields: common.MapStr{
+				"message": tt.message,
+				"service": common.MapStr{"name": "checkout"},
+			}}
+			got, err := newLogMapper(tt.ignoreFailure).ToLog(event)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ToLog() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package mapper
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ApacheCombinedFormat parses access logs in the combined log format of Apache and Nginx, e.g.
+// 127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /index.html HTTP/1.0" 200 2326 "http://ref/" "Mozilla/5.0"
+// The request line is the message and the level is derived from the status code.
+type ApacheCombinedFormat struct{}
+
+var apacheCombinedRegex = regexp.MustCompile(
+	`^(\S+) (\S+) (\S+) \[([^\]]+)\] "((?:[^"\\]|\\.)*)" (\d{3}) (\S+)(?: "((?:[^"\\]|\\.)*)" "((?:[^"\\]|\\.)*)")?`)
+
+func (ApacheCombinedFormat) ParseLine(line string) (*ParsedLine, error) {
+	matches := apacheCombinedRegex.FindStringSubmatch(line)
+	if matches == nil {
+		return nil, fmt.Errorf("line of length %v is not in apache combined log format", len(line))
+	}
+	timestamp, ok := parseTimestamp(matches[4], "02/Jan/2006:15:04:05 -0700")
+	if !ok {
+		return nil, fmt.Errorf("invalid apache timestamp %v", matches[4])
+	}
+	parsed := &ParsedLine{Timestamp: timestamp, Message: matches[5], Tags: make(map[string]string)}
+	status, _ := strconv.Atoi(matches[6])
+	switch {
+	case status >= 500:
+		parsed.Level = "ERROR"
+	case status >= 400:
+		parsed.Level = "WARNING"
+	default:
+		parsed.Level = "INFO"
+	}
+
+	tags := map[string]string{
+		"client_ip":  matches[1],
+		"user":       matches[3],
+		"status":     matches[6],
+		"bytes":      matches[7],
+		"referrer":   matches[8],
+		"user_agent": matches[9],
+	}
+	if request := strings.Fields(matches[5]); len(request) == 3 {
+		tags["method"], tags["path"], tags["protocol"] = request[0], request[1], request[2]
+	}
+	for name, value := range tags {
+		// Apache writes a dash for empty fields
+		if value != "" && value != "-" {
+			parsed.Tags[name] = value
+		}
+	}
+	return parsed, nil
+}
+
+// NginxErrorFormat parses the error log of Nginx, e.g.
+// 2022/04/04 09:00:35 [error] 1234#5678: *9 open() "/var/www/favicon.ico" failed, client: 10.0.0.1, server: example
+// The trailing context fields like client, server and request are moved from the message to the tags.
+type NginxErrorFormat struct{}
+
+var (
+	nginxErrorRegex   = regexp.MustCompile(`^(\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2}) \[(\w+)\] (\d+)#(\d+): (?:\*(\d+) )?(.*)$`)
+	nginxContextRegex = regexp.MustCompile(`, (client|server|request|upstream|host|referrer): ("(?:[^"\\]|\\.)*"|[^,]*)$`)
+)
+
+func (NginxErrorFormat) ParseLine(line string) (*ParsedLine, error) {
+	matches := nginxErrorRegex.FindStringSubmatch(line)
+	if matches == nil {
+		return nil, fmt.Errorf("line of length %v is not in nginx error log format", len(line))
+	}
+	timestamp, ok := parseTimestamp(matches[1], "2006/01/02 15:04:05")
+	if !ok {
+		return nil, fmt.Errorf("invalid nginx timestamp %v", matches[1])
+	}
+	parsed := &ParsedLine{
+		Timestamp: timestamp,
+		Level:     normalizeLevel(matches[2]),
+		Tags:      map[string]string{"pid": matches[3], "tid": matches[4]},
+	}
+	if matches[5] != "" {
+		parsed.Tags["connection"] = matches[5]
+	}
+
+	// The context fields are appended at the end, so they are stripped from the end one by one
+	message := matches[6]
+	for {
+		context := nginxContextRegex.FindStringSubmatchIndex(message)
+		if context == nil {
+			break
+		}
+		value := message[context[4]:context[5]]
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		}
+		parsed.Tags[message[context[2]:context[3]]] = value
+		message = message[:context[0]]
+	}
+	parsed.Message = message
+	return parsed, nil
+}
@@ -0,0 +1,101 @@
+package mapper
+
+import (
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/common"
+	"strings"
+)
+
+// Defaults of the annotations which hold the hints
+const (
+	DefaultHintsField  = "kubernetes.annotations"
+	DefaultHintsPrefix = "co.elastic.logs/logsight"
+)
+
+// ApplicationTag is the tag which is set by the application hint
+const ApplicationTag = "application"
+
+// Hints override the mapping of single events. They are set by the annotations of the pod which wrote the event.
+type Hints struct {
+	MessageKey   string
+	TimestampKey string
+	LevelKey     string
+	// Tags are constant tags, e.g. the annotation co.elastic.logs/logsight.tags.team: payments adds the tag team
+	Tags map[string]string
+}
+
+// AnnotationHints reads Hints from the annotations in Field of an event. Annotations are used if their name starts
+// with the Prefix, followed by application, message_key, timestamp_key, level_key or tags.<tag>. Annotations whose
+// dots were replaced by underscores (dedot) are supported as well.
+type AnnotationHints struct {
+	Field  string
+	Prefix string
+}
+
+// read returns nil if the event has no hints
+func (ah *AnnotationHints) read(event beat.Event) *Hints {
+	if ah == nil {
+		return nil
+	}
+	value, err := event.GetValue(ah.Field)
+	if err != nil {
+		return nil
+	}
+	var annotations common.MapStr
+	switch v := value.(type) {
+	case common.MapStr:
+		annotations = v
+	case map[string]interface{}:
+		annotations = v
+	default:
+		return nil
+	}
+
+	var hints *Hints
+	dedottedPrefix := common.DeDot(ah.Prefix)
+	for name, value := range annotations.Flatten() {
+		str, ok := value.(string)
+		if !ok {
+			continue
+		}
+		var hint, tagPrefix string
+		switch {
+		case strings.HasPrefix(name, ah.Prefix+"."):
+			hint, tagPrefix = strings.TrimPrefix(name, ah.Prefix+"."), "tags."
+		case strings.HasPrefix(name, dedottedPrefix+"_"):
+			hint, tagPrefix = strings.TrimPrefix(name, dedottedPrefix+"_"), "tags_"
+		default:
+			continue
+		}
+		if hints == nil {
+			hints = &Hints{Tags: map[string]string{}}
+		}
+		switch {
+		case hint == "application":
+			hints.Tags[ApplicationTag] = str
+		case hint == "message_key":
+			hints.MessageKey = str
+		case hint == "timestamp_key":
+			hints.TimestampKey = str
+		case hint == "level_key":
+			hints.LevelKey = str
+		case strings.HasPrefix(hint, tagPrefix) && len(hint) > len(tagPrefix):
+			hints.Tags[strings.TrimPrefix(hint, tagPrefix)] = str
+		}
+	}
+	return hints
+}
+
+// hintedString returns the string value of the hinted key. It is empty if there is no hint or the key does not
+// exist in the event.
+func hintedString(event beat.Event, key string) string {
+	if key == "" {
+		return ""
+	}
+	mapper := StringMapper{Mapper: KeyMapper{Key: key}}
+	value, err := mapper.doStringMap(event)
+	if err != nil {
+		return ""
+	}
+	return value
+}
@@ -0,0 +1,121 @@
+package mapper
+
+import (
+	"github.com/aiops/logsight-filebeat/plugin/api"
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/common"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnnotationHints_read(t *testing.T) {
+	hints := &AnnotationHints{Field: DefaultHintsField, Prefix: DefaultHintsPrefix}
+	tests := []struct {
+		name        string
+		annotations interface{}
+		want        *Hints
+	}{
+		{
+			name: "pass annotations",
+			annotations: common.MapStr{
+				"co.elastic.logs/logsight.application": "checkout",
+				"co.elastic.logs/logsight.level_key":   "json.severity",
+				"co.elastic.logs/logsight.tags.team":   "payments",
+				"co.elastic.logs/enabled":              "true",
+			},
+			want: &Hints{LevelKey: "json.severity", Tags: map[string]string{"application": "checkout", "team": "payments"}},
+		},
+		{
+			name: "pass dedotted annotations",
+			annotations: common.MapStr{
+				"co_elastic_logs/logsight_message_key":   "log",
+				"co_elastic_logs/logsight_timestamp_key": "time",
+				"co_elastic_logs/logsight_tags_team":     "payments",
+			},
+			want: &Hints{MessageKey: "log", TimestampKey: "time", Tags: map[string]string{"team": "payments"}},
+		},
+		{
+			name:        "pass no hints",
+			annotations: common.MapStr{"co.elastic.logs/enabled": "true"},
+			want:        nil,
+		},
+		{
+			name:        "pass no annotations",
+			annotations: nil,
+			want:        nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields := common.MapStr{"message": "started"}
+			if tt.annotations != nil {
+				fields["kubernetes"] = common.MapStr{"annotations": tt.annotations}
+			}
+			assert.Equal(t, tt.want, hints.read(beat.Event{Fields: fields}))
+		})
+	}
+}
+
+func TestLogMapper_ToLog_hints(t *testing.T) {
+	lm := &LogMapper{
+		TimestampMapper: &StringMapper{Mapper: KeyMapper{Key: "ts"}},
+		MessageMapper:   &StringMapper{Mapper: KeyMapper{Key: "message"}},
+		LevelMapper:     &StringMapper{Mapper: ConstantStringMapper{ConstantString: "info"}},
+		TagsMapper: &MultipleKeyValueStringMapper{
+			Mapper: MultipleKeyValueMapper{KeyValuePairs: map[string]string{"team": "team"}},
+		},
+		Hints: &AnnotationHints{Field: DefaultHintsField, Prefix: DefaultHintsPrefix},
+	}
+	tests := []struct {
+		name   string
+		fields common.MapStr
+		want   *api.Log
+	}{
+		{
+			name: "pass hints override mapping",
+			fields: common.MapStr{
+				"ts":       "2022-04-04T09:00:35Z",
+				"message":  "payment declined",
+				"severity": "warn",
+				"team":     "platform",
+				"kubernetes": common.MapStr{"annotations": common.MapStr{
+					"co.elastic.logs/logsight.level_key":   "severity",
+					"co.elastic.logs/logsight.application": "checkout",
+					"co.elastic.logs/logsight.tags.team":   "payments",
+				}},
+			},
+			want: &api.Log{
+				Timestamp: "2022-04-04T09:00:35Z",
+				Message:   "payment declined",
+				Level:     "WARN",
+				Tags:      map[string]string{"application": "checkout", "team": "payments"},
+			},
+		},
+		{
+			name: "pass missing hinted key falls back to mapping",
+			fields: common.MapStr{
+				"ts":      "2022-04-04T09:00:35Z",
+				"message": "payment accepted",
+				"kubernetes": common.MapStr{"annotations": common.MapStr{
+					"co.elastic.logs/logsight.level_key": "severity",
+				}},
+			},
+			want: &api.Log{
+				Timestamp: "2022-04-04T09:00:35Z",
+				Message:   "payment accepted",
+				Level:     "INFO",
+				Tags:      map[string]string{},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := lm.ToLog(beat.Event{Fields: tt.fields})
+			if err != nil {
+				t.Fatalf("ToLog() error = %v", err)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
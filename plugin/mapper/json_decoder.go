@@ -0,0 +1,57 @@
+package mapper
+
+import (
+	"encoding/json"
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/common"
+	"strings"
+)
+
+// JsonDecoder decodes a field of an event which contains a json object, so that the other mappers can resolve
+// their keys against the decoded fields. Fields which do not contain a json object are left as they are.
+type JsonDecoder struct {
+	// Field is the key of the field with the json string
+	Field string
+	// Target is the key under which the decoded object is stored. The decoded fields are merged into the root of
+	// the event if it is empty.
+	Target string
+	// OverwriteKeys allows decoded fields to replace existing fields when merged into the root of the event. The
+	// json field itself is always replaced by a decoded field with the same key.
+	OverwriteKeys bool
+}
+
+// decode returns a copy of the event with the decoded fields. The event itself is not modified, since it is reused
+// when the batch is retried.
+func (jd *JsonDecoder) decode(event beat.Event) beat.Event {
+	value, err := event.GetValue(jd.Field)
+	if err != nil {
+		return event
+	}
+	str, ok := value.(string)
+	if !ok {
+		return event
+	}
+	str = strings.TrimSpace(str)
+	if !strings.HasPrefix(str, "{") || !strings.HasSuffix(str, "}") {
+		return event
+	}
+	var decoded common.MapStr
+	if err := json.Unmarshal([]byte(str), &decoded); err != nil {
+		return event
+	}
+
+	event.Fields = event.Fields.Clone()
+	if jd.Target != "" {
+		_, _ = event.Fields.Put(jd.Target, decoded)
+		return event
+	}
+	if _, exists := decoded[jd.Field]; exists {
+		_ = event.Fields.Delete(jd.Field)
+	}
+	if jd.OverwriteKeys {
+		event.Fields.DeepUpdate(decoded)
+	} else {
+		event.Fields.DeepUpdateNoOverwrite(decoded)
+	}
+	return event
+}
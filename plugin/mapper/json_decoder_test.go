@@ -0,0 +1,104 @@
+package mapper
+
+import (
+	"github.com/aiops/logsight-filebeat/plugin/api"
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/common"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJsonDecoder_decode(t *testing.T) {
+	jsonMessage := `{"msg":"user logged in","level":"warn","http":{"status":"401"},"host":"container"}`
+	tests := []struct {
+		name    string
+		decoder JsonDecoder
+		fields  common.MapStr
+		want    common.MapStr
+	}{
+		{
+			name:    "pass merge into root",
+			decoder: JsonDecoder{Field: "message"},
+			fields:  common.MapStr{"message": jsonMessage, "host": "node-1"},
+			want: common.MapStr{
+				"message": jsonMessage,
+				"msg":     "user logged in",
+				"level":   "warn",
+				"http":    common.MapStr{"status": "401"},
+				"host":    "node-1",
+			},
+		},
+		{
+			name:    "pass overwrite keys",
+			decoder: JsonDecoder{Field: "message", OverwriteKeys: true},
+			fields:  common.MapStr{"message": jsonMessage, "host": "node-1"},
+			want: common.MapStr{
+				"message": jsonMessage,
+				"msg":     "user logged in",
+				"level":   "warn",
+				"http":    common.MapStr{"status": "401"},
+				"host":    "container",
+			},
+		},
+		{
+			name:    "pass json field is replaced by decoded field",
+			decoder: JsonDecoder{Field: "message"},
+			fields:  common.MapStr{"message": ` {"message":"decoded"} `},
+			want:    common.MapStr{"message": "decoded"},
+		},
+		{
+			name:    "pass target",
+			decoder: JsonDecoder{Field: "log", Target: "json"},
+			fields:  common.MapStr{"log": `{"level":"info"}`},
+			want:    common.MapStr{"log": `{"level":"info"}`, "json": common.MapStr{"level": "info"}},
+		},
+		{
+			name:    "pass no json",
+			decoder: JsonDecoder{Field: "message"},
+			fields:  common.MapStr{"message": "{not json}"},
+			want:    common.MapStr{"message": "{not json}"},
+		},
+		{
+			name:    "pass missing field",
+			decoder: JsonDecoder{Field: "message"},
+			fields:  common.MapStr{"log": `{"level":"info"}`},
+			want:    common.MapStr{"log": `{"level":"info"}`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := tt.fields.Clone()
+			got := tt.decoder.decode(beat.Event{Fields: tt.fields})
+			assert.Equal(t, tt.want, got.Fields)
+			assert.Equal(t, original, tt.fields, "decode() must not modify the event")
+		})
+	}
+}
+
+func TestLogMapper_ToLog_jsonDecoder(t *testing.T) {
+	lm := &LogMapper{
+		TimestampMapper: &StringMapper{Mapper: KeyMapper{Key: "ts"}},
+		MessageMapper:   &StringMapper{Mapper: KeyMapper{Key: "msg"}},
+		LevelMapper:     &StringMapper{Mapper: KeyMapper{Key: "level"}},
+		TagsMapper: &MultipleKeyValueStringMapper{
+			Mapper: MultipleKeyValueMapper{map[string]string{"status": "http.status", "host": "host.name"}},
+		},
+		JsonDecoder: &JsonDecoder{Field: "message"},
+	}
+	event := beat.Event{Fields: common.MapStr{
+		"message": `{"ts":"2022-04-04T09:00:35Z","level":"error","msg":"request failed","http":{"status":"500"}}`,
+		"host":    common.MapStr{"name": "node-1"},
+	}}
+	want := &api.Log{
+		Timestamp: "2022-04-04T09:00:35Z",
+		Message:   "request failed",
+		Level:     "ERROR",
+		Tags:      map[string]string{"status": "500", "host": "node-1"},
+	}
+	got, err := lm.ToLog(event)
+	if err != nil {
+		t.Fatalf("ToLog() error = %v", err)
+	}
+	assert.Equal(t, want, got)
+}
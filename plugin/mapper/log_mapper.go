@@ -3,8 +3,11 @@ package mapper
 import (
 	"github.com/aiops/logsight-filebeat/plugin/api"
 	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/conditions"
 	"github.com/elastic/beats/v7/libbeat/publisher"
 	"strings"
+	"sync"
+	"sync/atomic"
 )
 
 type FailedMapping struct {
@@ -23,31 +26,148 @@ type LogMapper struct {
 	MessageMapper   *StringMapper
 	LevelMapper     *StringMapper
 	TagsMapper      *MultipleKeyValueStringMapper
+	// MessageParsers are applied in order to the mapped message
+	MessageParsers []*MessageParser
+	// FormatMapper is optional. The fields it parses take precedence, the other mappers are used for the fields
+	// which are not part of the format.
+	FormatMapper *FormatMapper
+	// JsonDecoder is optional. It is applied before all other mappers.
+	JsonDecoder *JsonDecoder
+	// PatternTagsMapper is optional. Its tags are added after the explicitly mapped tags and the tags of the format. Its
+	// MaxTags limits all tags.
+	PatternTagsMapper *PatternTagsMapper
+	// Rules are checked in order before the mappers above are applied. The first matching rule maps the event.
+	Rules []*MappingRule
+	// Hints is optional. The hints of an event take precedence over the mappers of the matching rule.
+	Hints *AnnotationHints
+	// Workers is the number of goroutines which map the events of large batches in ToLogs. The events are mapped
+	// sequentially if it is 0 or 1.
+	Workers int
+
+	// droppedTags counts the tags which were dropped since their values could not be converted to strings
+	droppedTags uint64
 }
 
-func (lm *LogMapper) ToLog(event beat.Event) (*api.Log, error) {
-	timestamp, err := lm.TimestampMapper.doStringMap(event)
-	if err != nil {
-		return nil, err
+// TakeDroppedTags returns the number of tags which were dropped since the last call, including those of the rules.
+func (lm *LogMapper) TakeDroppedTags() uint64 {
+	dropped := atomic.SwapUint64(&lm.droppedTags, 0)
+	for _, rule := range lm.Rules {
+		dropped += rule.Mapper.TakeDroppedTags()
 	}
-	message, err := lm.MessageMapper.doStringMap(event)
+	return dropped
+}
+
+// MappingRule maps the events which match the Condition with its own Mapper
+type MappingRule struct {
+	Condition conditions.Condition
+	Mapper    *LogMapper
+}
+
+// unparsed and noHints are shared by all events without a format or hints, they must not be modified
+var (
+	unparsed = &ParsedLine{}
+	noHints  = &Hints{}
+)
+
+func (lm *LogMapper) parseFormat(event beat.Event) (*ParsedLine, error) {
+	if lm.FormatMapper == nil {
+		return unparsed, nil
+	}
+	parsed, err := lm.FormatMapper.doParse(event)
 	if err != nil {
+		if lm.FormatMapper.IgnoreFailure {
+			return unparsed, nil
+		}
 		return nil, err
 	}
-	level, err := lm.LevelMapper.doStringMap(event)
+	return parsed, nil
+}
+
+func (lm *LogMapper) ToLog(event beat.Event) (*api.Log, error) {
+	return lm.toLog(event, lm.Hints.read(event))
+}
+
+func (lm *LogMapper) toLog(event beat.Event, hints *Hints) (*api.Log, error) {
+	for _, rule := range lm.Rules {
+		if rule.Condition.Check(&event) {
+			return rule.Mapper.toLog(event, hints)
+		}
+	}
+	if hints == nil {
+		hints = noHints
+	}
+	if lm.JsonDecoder != nil {
+		event = lm.JsonDecoder.decode(event)
+	}
+	parsed, err := lm.parseFormat(event)
 	if err != nil {
 		return nil, err
 	}
-	tags, err := lm.TagsMapper.DoMultipleStringMap(event)
+	timestamp := hintedString(event, hints.TimestampKey)
+	if timestamp == "" {
+		timestamp = parsed.Timestamp
+	}
+	if timestamp == "" {
+		timestamp, err = lm.TimestampMapper.doStringMap(event)
+		if err != nil {
+			return nil, err
+		}
+	}
+	message := hintedString(event, hints.MessageKey)
+	if message == "" {
+		message = parsed.Message
+	}
+	if message == "" {
+		message, err = lm.MessageMapper.doStringMap(event)
+		if err != nil {
+			return nil, err
+		}
+	}
+	level := hintedString(event, hints.LevelKey)
+	if level == "" {
+		level = parsed.Level
+	}
+	if level == "" {
+		level, err = lm.LevelMapper.doStringMap(event)
+		if err != nil {
+			return nil, err
+		}
+	}
+	tags, droppedTags, err := lm.TagsMapper.DoMultipleStringMap(event)
 	if err != nil {
 		return nil, err
 	}
+	// Hinted tags take precedence over the explicitly mapped tags
+	for name, value := range hints.Tags {
+		tags[name] = value
+	}
+	// Explicitly mapped tags take precedence over the tags of the format
+	for name, value := range parsed.Tags {
+		if _, exists := tags[name]; !exists {
+			tags[name] = value
+		}
+	}
+	if lm.PatternTagsMapper != nil {
+		droppedTags += lm.PatternTagsMapper.apply(tags, event)
+	}
 	log := &api.Log{
 		Timestamp: timestamp,
 		Message:   message,
 		Level:     strings.ToUpper(level),
 		Tags:      tags,
 	}
+	for _, parser := range lm.MessageParsers {
+		if err := parser.apply(log); err != nil {
+			return nil, err
+		}
+	}
+	if lm.PatternTagsMapper != nil {
+		// The message parsers can add tags as well
+		droppedTags += lm.PatternTagsMapper.limit(log.Tags)
+	}
+	if droppedTags > 0 {
+		atomic.AddUint64(&lm.droppedTags, uint64(droppedTags))
+	}
 	err = log.ValidateLog()
 	if err != nil {
 		return nil, err
@@ -55,25 +175,57 @@ func (lm *LogMapper) ToLog(event beat.Event) (*api.Log, error) {
 	return log, nil
 }
 
+// minEventsPerWorker avoids that small batches are split across workers, which would cost more than it saves
+const minEventsPerWorker = 32
+
+// ToLogs maps the events in their order. The events which could not be mapped are returned as failed mappings.
 func (lm *LogMapper) ToLogs(events []publisher.Event) ([]*api.Log, []*FailedMapping) {
+	mapped := make([]*api.Log, len(events))
+	errs := make([]error, len(events))
+	lm.mapEvents(events, mapped, errs)
+
 	var logs []*api.Log
 	var failedMappings []*FailedMapping
-
-	for _, event := range events {
-		log, err := lm.ToLog(event.Content)
-		if err != nil {
+	for i := range events {
+		if errs[i] != nil {
 			failedMappings = append(failedMappings, &FailedMapping{
-				Event: &event,
-				Err:   &err,
+				Event: &events[i],
+				Err:   &errs[i],
 			})
 			continue
 		}
-		logs = append(logs, log)
+		logs = append(logs, mapped[i])
 	}
+	return logs, failedMappings
+}
 
-	if len(failedMappings) > 0 {
-		return logs, nil
-	} else {
-		return logs, failedMappings
+// mapEvents maps each event to the log and error with the same index. With more than one of Workers, the events
+// are split into consecutive chunks which are mapped concurrently.
+func (lm *LogMapper) mapEvents(events []publisher.Event, logs []*api.Log, errs []error) {
+	workers := lm.Workers
+	if maxWorkers := len(events) / minEventsPerWorker; workers > maxWorkers {
+		workers = maxWorkers
+	}
+	if workers <= 1 {
+		for i := range events {
+			logs[i], errs[i] = lm.ToLog(events[i].Content)
+		}
+		return
+	}
+	chunkSize := (len(events) + workers - 1) / workers
+	var wg sync.WaitGroup
+	for start := 0; start < len(events); start += chunkSize {
+		end := start + chunkSize
+		if end > len(events) {
+			end = len(events)
+		}
+		wg.Add(1)
+		go func(start int, end int) {
+			defer wg.Done()
+			for i := start; i < end; i++ {
+				logs[i], errs[i] = lm.ToLog(events[i].Content)
+			}
+		}(start, end)
 	}
+	wg.Wait()
 }
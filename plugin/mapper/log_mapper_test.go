@@ -1,10 +1,14 @@
 package mapper
 
 import (
+	"fmt"
 	"github.com/aiops/logsight-filebeat/plugin/api"
 	"github.com/elastic/beats/v7/libbeat/beat"
 	"github.com/elastic/beats/v7/libbeat/common"
+	"github.com/elastic/beats/v7/libbeat/conditions"
+	"github.com/elastic/beats/v7/libbeat/publisher"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -139,3 +143,182 @@ func TestLogMapper_doMap(t *testing.T) {
 		})
 	}
 }
+
+func TestLogMapper_ToLog_messageParsers(t *testing.T) {
+	dissectParser, _ := NewDissectParser("%{client_ip} %{method} %{path} - %{message}")
+	lm := &LogMapper{
+		TimestampMapper: &StringMapper{Mapper: &ConstantStringMapper{ConstantString: "2022-04-01T20:10:57+02:00"}},
+		MessageMapper:   &StringMapper{Mapper: KeyMapper{Key: "message"}},
+		LevelMapper:     &StringMapper{Mapper: &ConstantStringMapper{ConstantString: "INFO"}},
+		TagsMapper: &MultipleKeyValueStringMapper{
+			Mapper: MultipleKeyValueMapper{map[string]string{"host": "host.name"}},
+		},
+		MessageParsers: []*MessageParser{{Parser: dissectParser, MessageField: "message"}},
+	}
+	event := beat.Event{Fields: common.MapStr{
+		"message": "10.0.0.1 GET /index.html - request took 12 ms",
+		"host":    common.MapStr{"name": "web-1"},
+	}}
+	want := &api.Log{
+		Timestamp: "2022-04-01T20:10:57+02:00",
+		Message:   "request took 12 ms",
+		Level:     "INFO",
+		Tags:      map[string]string{"host": "web-1", "client_ip": "10.0.0.1", "method": "GET", "path": "/index.html"},
+	}
+	got, err := lm.ToLog(event)
+	if err != nil {
+		t.Fatalf("ToLog() error = %v", err)
+	}
+	assert.Equal(t, want, got)
+}
+
+func TestLogMapper_ToLog_rules(t *testing.T) {
+	newCondition := func(config *conditions.Config) conditions.Condition {
+		condition, err := conditions.NewCondition(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return condition
+	}
+	newKeyLogMapper := func(messageKey string, level string) *LogMapper {
+		return &LogMapper{
+			TimestampMapper: &StringMapper{Mapper: &ConstantStringMapper{ConstantString: "2022-04-01T20:10:57+02:00"}},
+			MessageMapper:   &StringMapper{Mapper: KeyMapper{Key: messageKey}},
+			LevelMapper:     &StringMapper{Mapper: &ConstantStringMapper{ConstantString: level}},
+			TagsMapper:      &MultipleKeyValueStringMapper{Mapper: MultipleKeyValueMapper{map[string]string{}}},
+		}
+	}
+	lm := newKeyLogMapper("message", "INFO")
+	lm.Rules = []*MappingRule{
+		{
+			Condition: newCondition(&conditions.Config{HasFields: []string{"nginx.error"}}),
+			Mapper:    newKeyLogMapper("nginx.error", "ERROR"),
+		},
+		{
+			// Also matches nginx errors, but the first matching rule wins
+			Condition: newCondition(&conditions.Config{HasFields: []string{"nginx"}}),
+			Mapper:    newKeyLogMapper("nginx.access", "DEBUG"),
+		},
+	}
+	tests := []struct {
+		name      string
+		fields    common.MapStr
+		wantLevel string
+		wantErr   bool
+	}{
+		{name: "pass first rule", fields: common.MapStr{"nginx": common.MapStr{"error": "failed"}}, wantLevel: "ERROR"},
+		{name: "pass second rule", fields: common.MapStr{"nginx": common.MapStr{"access": "GET /"}}, wantLevel: "DEBUG"},
+		{name: "pass no rule matches", fields: common.MapStr{"message": "started"}, wantLevel: "INFO"},
+		{name: "fail matching rule does not fall back", fields: common.MapStr{"nginx": common.MapStr{}, "message": "x"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := lm.ToLog(beat.Event{Fields: tt.fields})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ToLog() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && got.Level != tt.wantLevel {
+				t.Errorf("ToLog() level = %v, want %v", got.Level, tt.wantLevel)
+			}
+		})
+	}
+}
+
+func BenchmarkLogMapper_ToLog(b *testing.B) {
+	lm := &LogMapper{
+		TimestampMapper: &StringMapper{Mapper: EventTimeMapper{}},
+		MessageMapper:   &StringMapper{Mapper: KeyMapper{Key: "message"}},
+		LevelMapper:     &StringMapper{Mapper: ConstantStringMapper{ConstantString: "INFO"}},
+		TagsMapper: &MultipleKeyValueStringMapper{
+			Mapper: MultipleKeyValueMapper{KeyValuePairs: map[string]string{
+				"host":      "host.name",
+				"namespace": "kubernetes.namespace",
+				"pod":       "kubernetes.pod.name",
+			}},
+		},
+	}
+	event := beat.Event{
+		Timestamp: time.Date(2022, 4, 4, 9, 0, 35, 0, time.UTC),
+		Fields: common.MapStr{
+			"message":    "GET /api/v1/orders 200 12ms",
+			"host":       common.MapStr{"name": "node-1"},
+			"kubernetes": common.MapStr{"namespace": "shop", "pod": common.MapStr{"name": "cart-7d9f"}},
+		},
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := lm.ToLog(event); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func newSyslogLogMapper(workers int) *LogMapper {
+	messageMapper := StringMapper{Mapper: KeyMapper{Key: "message"}}
+	return &LogMapper{
+		TimestampMapper: &StringMapper{Mapper: EventTimeMapper{}},
+		MessageMapper:   &messageMapper,
+		LevelMapper:     &StringMapper{Mapper: ConstantStringMapper{ConstantString: "INFO"}},
+		TagsMapper:      &MultipleKeyValueStringMapper{Mapper: MultipleKeyValueMapper{KeyValuePairs: map[string]string{}}},
+		FormatMapper:    &FormatMapper{Source: messageMapper, Format: SyslogRFC5424Format{}},
+		Workers:         workers,
+	}
+}
+
+func syslogEvents(n int) []publisher.Event {
+	events := make([]publisher.Event, n)
+	for i := range events {
+		message := fmt.Sprintf(`<165>1 2022-04-04T09:00:35.%03dZ web-1 shop 42 ID47 [meta seq="%v"] order %v placed`, i%1000, i, i)
+		if i%10 == 3 {
+			// not mappable, since the event has no message
+			message = ""
+		}
+		events[i] = publisher.Event{Content: beat.Event{Timestamp: time.Now(), Fields: common.MapStr{}}}
+		if message != "" {
+			events[i].Content.Fields["message"] = message
+		}
+	}
+	return events
+}
+
+func TestLogMapper_ToLogs(t *testing.T) {
+	events := syslogEvents(500)
+	want, wantFailed := newSyslogLogMapper(0).ToLogs(events)
+	assert.Len(t, want, 450)
+	assert.Len(t, wantFailed, 50)
+	for i, failed := range wantFailed {
+		assert.Same(t, &events[i*10+3], failed.Event)
+		assert.Error(t, *failed.Err)
+	}
+
+	tests := []struct {
+		name    string
+		workers int
+	}{
+		{name: "pass one worker", workers: 1},
+		{name: "pass four workers", workers: 4},
+		{name: "pass more workers than events", workers: 1000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, gotFailed := newSyslogLogMapper(tt.workers).ToLogs(events)
+			assert.Equal(t, want, got)
+			assert.Equal(t, wantFailed, gotFailed)
+		})
+	}
+}
+
+func BenchmarkLogMapper_ToLogs(b *testing.B) {
+	events := syslogEvents(2000)
+	// The speed-up depends on the number of CPU cores
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%v", workers), func(b *testing.B) {
+			lm := newSyslogLogMapper(workers)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				lm.ToLogs(events)
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/elastic/beats/v7/libbeat/beat"
 	"regexp"
+	"strings"
 	"time"
 )
 
@@ -16,7 +17,16 @@ type StringMapper struct {
 	Mapper Mapper
 }
 
+// directStringMapper is implemented by mappers whose results are always strings. They are called directly, so the
+// result does not need to be converted to an interface and back.
+type directStringMapper interface {
+	doString(event beat.Event) string
+}
+
 func (sm *StringMapper) doStringMap(event beat.Event) (string, error) {
+	if direct, ok := sm.Mapper.(directStringMapper); ok {
+		return direct.doString(event), nil
+	}
 	v, err := sm.Mapper.DoMap(event)
 	if err != nil {
 		return "", err
@@ -27,7 +37,10 @@ func (sm *StringMapper) doStringMap(event beat.Event) (string, error) {
 func (sm *StringMapper) checkString(value interface{}) (string, error) {
 	switch ty := value.(type) {
 	case string:
-		return fmt.Sprintf("%v", value), nil
+		return ty, nil
+	case time.Time:
+		// e.g. the @timestamp of an event
+		return ty.Format(time.RFC3339Nano), nil
 	default:
 		return "", fmt.Errorf("result of applying Mapper %v on string %v is not a string but %v",
 			sm.Mapper, value, ty)
@@ -43,6 +56,10 @@ func (cm ConstantStringMapper) DoMap(beat.Event) (interface{}, error) {
 	return cm.ConstantString, nil
 }
 
+func (cm ConstantStringMapper) doString(beat.Event) string {
+	return cm.ConstantString
+}
+
 type generator interface {
 	generate() (string, error)
 }
@@ -52,8 +69,7 @@ type ISO8601TimestampGenerator struct {
 }
 
 func (tg ISO8601TimestampGenerator) generate() (string, error) {
-	timeStr := fmt.Sprintf("%v", time.Now().Format(time.RFC3339))
-	return timeStr, nil
+	return time.Now().Format(time.RFC3339), nil
 }
 
 // GeneratorMapper expects a generator to generate values when DoMap is called.
@@ -75,7 +91,11 @@ type EventTimeMapper struct {
 }
 
 func (etm EventTimeMapper) DoMap(event beat.Event) (interface{}, error) {
-	return fmt.Sprintf(event.Timestamp.Format(time.RFC3339)), nil
+	return etm.doString(event), nil
+}
+
+func (etm EventTimeMapper) doString(event beat.Event) string {
+	return event.Timestamp.Format(time.RFC3339)
 }
 
 // KeyMapper searches for the Key in a common.MapStr object and returns the values
@@ -87,10 +107,56 @@ func (km KeyMapper) DoMap(event beat.Event) (interface{}, error) {
 	if v, err := event.GetValue(km.Key); err == nil {
 		return v, nil
 	} else {
-		return "", fmt.Errorf("Key %v not found in logp %v", km.Key, event)
+		return "", fmt.Errorf("key %v not found in event", km.Key)
 	}
 }
 
+// FallbackMapper applies the Mappers in order and returns the first result which is a non-empty string. If none of
+// them succeeds, the result of the Default mapper is returned. Default is optional.
+type FallbackMapper struct {
+	Mappers []Mapper
+	Default Mapper
+}
+
+// DoMap reports only the keys which were tried if all Mappers fail, since the errors of the Mappers and their results
+// can contain the content of the event.
+func (fm FallbackMapper) DoMap(event beat.Event) (interface{}, error) {
+	for _, mapper := range fm.Mappers {
+		value, err := mapper.DoMap(event)
+		if err != nil {
+			continue
+		}
+		if str, ok := value.(string); ok && str == "" {
+			continue
+		}
+		return value, nil
+	}
+	if fm.Default != nil {
+		return fm.Default.DoMap(event)
+	}
+	return "", fmt.Errorf("all fallback mappers failed, tried keys %v", strings.Join(fm.keys(), ", "))
+}
+
+// keys returns the keys of the event which are read by the Mappers
+func (fm FallbackMapper) keys() []string {
+	var keys []string
+	for _, mapper := range fm.Mappers {
+		switch mapper := mapper.(type) {
+		case KeyMapper:
+			keys = append(keys, mapper.Key)
+		case *KeyMapper:
+			keys = append(keys, mapper.Key)
+		case *TemplateMapper:
+			keys = append(keys, mapper.Template.Fields()...)
+		case FallbackMapper:
+			keys = append(keys, mapper.keys()...)
+		default:
+			keys = append(keys, fmt.Sprintf("%T", mapper))
+		}
+	}
+	return keys
+}
+
 // MultipleKeyValueMapper searches for all given Keys in a common.MapStr object and returns the values together with the
 // configured key values
 type MultipleKeyValueMapper struct {
@@ -107,26 +173,40 @@ func (mkvm MultipleKeyValueMapper) DoMap(event beat.Event) (interface{}, error)
 	return values, nil
 }
 
-// MultipleKeyValueStringMapper is a wrapper around MultipleKeyValueMapper to ensure that the mapping results are strings
+// MultipleKeyValueStringMapper is a wrapper around MultipleKeyValueMapper to ensure that the mapping results are strings.
+// Values which are not strings are converted by the Coercer. Without Coercer, they are dropped.
 type MultipleKeyValueStringMapper struct {
 	StringMapper
-	Mapper MultipleKeyValueMapper
-}
-
-func (msm *MultipleKeyValueStringMapper) DoMultipleStringMap(event beat.Event) (map[string]string, error) {
-	var result = make(map[string]string)
-	values, err := msm.Mapper.DoMap(event)
-	if err != nil {
-		return nil, err
-	}
-	for k, v := range values.(map[string]interface{}) {
-		// TODO better handling of failed mappings (somehow log errors later)
-		checkedValue, err := msm.checkString(v)
-		if err == nil {
-			result[k] = checkedValue
+	Mapper  MultipleKeyValueMapper
+	Coercer *TagCoercer
+}
+
+// DoMultipleStringMap returns the mapped strings and the number of values which were dropped since they could not be
+// converted to strings.
+func (msm *MultipleKeyValueStringMapper) DoMultipleStringMap(event beat.Event) (map[string]string, int, error) {
+	// The keys are looked up directly instead of calling Mapper.DoMap, which collects the values in another map
+	result := make(map[string]string, len(msm.Mapper.KeyValuePairs))
+	dropped := 0
+	for name, key := range msm.Mapper.KeyValuePairs {
+		value, err := event.GetValue(key)
+		if err != nil {
+			continue
+		}
+		if msm.Coercer != nil {
+			if !msm.Coercer.coerce(name, value, result) {
+				dropped++
+			}
+			continue
+		}
+		if str, ok := value.(string); ok {
+			result[name] = str
+		} else if checkedValue, err := msm.checkString(value); err == nil {
+			result[name] = checkedValue
+		} else {
+			dropped++
 		}
 	}
-	return result, nil
+	return result, dropped, nil
 }
 
 type KeyRegexMapper struct {
@@ -6,6 +6,7 @@ import (
 	"github.com/elastic/beats/v7/libbeat/common"
 	"regexp"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -275,6 +276,13 @@ func TestStringMapper_doStringMap(t *testing.T) {
 			want:    "",
 			wantErr: false,
 		},
+		{
+			name:    "pass event timestamp",
+			fields:  fields{mapper: &KeyMapper{"@timestamp"}},
+			args:    args{event: beat.Event{Timestamp: time.Date(2022, 4, 4, 9, 0, 35, 0, time.UTC)}},
+			want:    "2022-04-04T09:00:35Z",
+			wantErr: false,
+		},
 		{
 			name:    "fail int",
 			fields:  fields{mapper: &KeyMapper{"key4"}},
@@ -297,3 +305,79 @@ func TestStringMapper_doStringMap(t *testing.T) {
 		})
 	}
 }
+
+func TestFallbackMapper_DoMap(t *testing.T) {
+	testEvent := beat.Event{Fields: common.MapStr{
+		"log":   common.MapStr{"level": "warn"},
+		"level": "info",
+		"empty": "",
+		"code":  4,
+	}}
+	tests := []struct {
+		name    string
+		mapper  FallbackMapper
+		want    interface{}
+		wantErr bool
+	}{
+		{
+			name:    "pass first key",
+			mapper:  FallbackMapper{Mappers: []Mapper{KeyMapper{Key: "log.level"}, KeyMapper{Key: "level"}}},
+			want:    "warn",
+			wantErr: false,
+		},
+		{
+			name:    "pass missing and empty keys are skipped",
+			mapper:  FallbackMapper{Mappers: []Mapper{KeyMapper{Key: "severity"}, KeyMapper{Key: "empty"}, KeyMapper{Key: "level"}}},
+			want:    "info",
+			wantErr: false,
+		},
+		{
+			name:    "pass non-string value",
+			mapper:  FallbackMapper{Mappers: []Mapper{KeyMapper{Key: "code"}}},
+			want:    4,
+			wantErr: false,
+		},
+		{
+			name: "pass default",
+			mapper: FallbackMapper{
+				Mappers: []Mapper{KeyMapper{Key: "severity"}},
+				Default: ConstantStringMapper{ConstantString: "INFO"},
+			},
+			want:    "INFO",
+			wantErr: false,
+		},
+		{
+			name:    "fail no key and no default",
+			mapper:  FallbackMapper{Mappers: []Mapper{KeyMapper{Key: "severity"}, KeyMapper{Key: "empty"}}},
+			want:    "",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.mapper.DoMap(testEvent)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("DoMap() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestFallbackMapper_DoMap_error(t *testing.T) {
+	testEvent := beat.Event{Fields: common.MapStr{"message": "login of jane@example.com", "empty": ""}}
+	template, err := NewTemplateMapper("%{[user.name]} %{[message]}")
+	if err != nil {
+		t.Fatalf("NewTemplateMapper() error = %v", err)
+	}
+	fm := FallbackMapper{Mappers: []Mapper{
+		template,
+		FallbackMapper{Mappers: []Mapper{KeyMapper{Key: "msg"}, KeyMapper{Key: "empty"}}},
+	}}
+	_, err = fm.DoMap(testEvent)
+	if err == nil {
+		t.Fatalf("DoMap() error = nil, want error")
+	}
+	assert.Equal(t, "all fallback mappers failed, tried keys user.name, message, msg, empty", err.Error())
+}
@@ -0,0 +1,116 @@
+package mapper
+
+import (
+	"fmt"
+	"github.com/aiops/logsight-filebeat/plugin/api"
+	"github.com/elastic/beats/v7/libbeat/processors/dissect"
+	"regexp"
+)
+
+// Parser extracts named fields from a string
+type Parser interface {
+	Parse(value string) (map[string]string, error)
+}
+
+// RegexParser extracts the named capture groups of a regular expression, e.g. (?P<status>\d{3})
+type RegexParser struct {
+	Expr *regexp.Regexp
+}
+
+func NewRegexParser(expr string) (*RegexParser, error) {
+	compiled, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("%w; invalid regex expression %v", err, expr)
+	}
+	hasNamedGroup := false
+	for _, name := range compiled.SubexpNames() {
+		hasNamedGroup = hasNamedGroup || name != ""
+	}
+	if !hasNamedGroup {
+		return nil, fmt.Errorf("regex expression %v has no named capture groups", expr)
+	}
+	return &RegexParser{Expr: compiled}, nil
+}
+
+func (rp *RegexParser) Parse(value string) (map[string]string, error) {
+	matches := rp.Expr.FindStringSubmatch(value)
+	if matches == nil {
+		return nil, fmt.Errorf("no matches found with regular expression %v in string of length %v", rp.Expr, len(value))
+	}
+	fields := make(map[string]string)
+	for i, name := range rp.Expr.SubexpNames() {
+		// Optional groups which did not participate in the match are skipped
+		if name != "" && matches[i] != "" {
+			fields[name] = matches[i]
+		}
+	}
+	return fields, nil
+}
+
+// DissectParser extracts fields with a dissect pattern, e.g. %{client_ip} - %{user} [%{time}] %{message}
+type DissectParser struct {
+	Dissector *dissect.Dissector
+}
+
+func NewDissectParser(pattern string) (*DissectParser, error) {
+	dissector, err := dissect.New(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("%w; invalid dissect pattern %v", err, pattern)
+	}
+	return &DissectParser{Dissector: dissector}, nil
+}
+
+func (dp *DissectParser) Parse(value string) (map[string]string, error) {
+	fields, err := dp.Dissector.Dissect(value)
+	if err != nil {
+		// The dissect error repeats the unmatched part of the string, which may contain sensitive data
+		return nil, fmt.Errorf("dissect pattern %v does not match string of length %v", dp.Dissector.Raw(), len(value))
+	}
+	return fields, nil
+}
+
+// MessageParser applies a Parser to the message of a log. The extracted fields are added to the tags, unless a tag
+// with the same name was already mapped. If MessageField is set, the message is replaced by the extracted field with
+// this name, which allows to strip variable prefixes from the message.
+type MessageParser struct {
+	Parser        Parser
+	MessageField  string
+	TagsPrefix    string
+	IgnoreFailure bool
+}
+
+func (mp *MessageParser) apply(log *api.Log) error {
+	fields, err := mp.Parser.Parse(log.Message)
+	if err != nil {
+		if mp.IgnoreFailure {
+			return nil
+		}
+		return err
+	}
+
+	if mp.MessageField != "" {
+		message, ok := fields[mp.MessageField]
+		if !ok || message == "" {
+			if mp.IgnoreFailure {
+				return nil
+			}
+			return fmt.Errorf("message field %v was not extracted from message of length %v", mp.MessageField,
+				len(log.Message))
+		}
+		log.Message = message
+	}
+
+	if log.Tags == nil {
+		log.Tags = make(map[string]string)
+	}
+	for name, value := range fields {
+		if name == mp.MessageField {
+			continue
+		}
+		tag := mp.TagsPrefix + name
+		if _, exists := log.Tags[tag]; !exists {
+			log.Tags[tag] = value
+		}
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package mapper
+
+import (
+	"github.com/aiops/logsight-filebeat/plugin/api"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewRegexParser(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		wantErr bool
+	}{
+		{name: "pass named groups", expr: `^(?P<ip>\S+) (?P<message>.*)$`, wantErr: false},
+		{name: "fail no named groups", expr: `^(\S+) (.*)$`, wantErr: true},
+		{name: "fail invalid regex", expr: `^(?P<ip>\S+`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewRegexParser(tt.expr); (err != nil) != tt.wantErr {
+				t.Errorf("NewRegexParser() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRegexParser_Parse(t *testing.T) {
+	parser, _ := NewRegexParser(`^(?P<ip>\S+) (?P<method>GET|POST) (?P<path>\S+)(?: (?P<status>\d{3}))?`)
+	tests := []struct {
+		name    string
+		value   string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name:    "pass",
+			value:   "10.0.0.1 GET /index.html 200",
+			want:    map[string]string{"ip": "10.0.0.1", "method": "GET", "path": "/index.html", "status": "200"},
+			wantErr: false,
+		},
+		{
+			name:    "pass optional group",
+			value:   "10.0.0.1 POST /login",
+			want:    map[string]string{"ip": "10.0.0.1", "method": "POST", "path": "/login"},
+			wantErr: false,
+		},
+		{
+			name:    "fail no match",
+			value:   "10.0.0.1 DELETE /index.html",
+			want:    nil,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parser.Parse(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				assert.NotContains(t, err.Error(), tt.value)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestDissectParser_Parse(t *testing.T) {
+	parser, err := NewDissectParser("%{ts} %{level} [%{thread}] %{message}")
+	if err != nil {
+		t.Fatalf("NewDissectParser() error = %v", err)
+	}
+	tests := []struct {
+		name    string
+		value   string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name:  "pass",
+			value: "2022-04-04T09:00:35 INFO [main] Started application in 3.2 seconds",
+			want: map[string]string{
+				"ts":      "2022-04-04T09:00:35",
+				"level":   "INFO",
+				"thread":  "main",
+				"message": "Started application in 3.2 seconds",
+			},
+			wantErr: false,
+		},
+		{
+			name:    "fail no match",
+			value:   "unstructured",
+			want:    nil,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parser.Parse(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				assert.NotContains(t, err.Error(), tt.value)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestMessageParser_apply(t *testing.T) {
+	regexParser, _ := NewRegexParser(`^(?P<ip>\S+) \[(?P<thread>\w+)\] (?P<text>.*)$`)
+	message := "10.0.0.1 [worker1] Connection reset by peer"
+
+	type fields struct {
+		messageField  string
+		tagsPrefix    string
+		ignoreFailure bool
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		log     api.Log
+		want    api.Log
+		wantErr bool
+	}{
+		{
+			name:   "pass tags",
+			fields: fields{},
+			log:    api.Log{Message: message, Tags: map[string]string{"ip": "mapped"}},
+			want: api.Log{
+				Message: message,
+				Tags:    map[string]string{"ip": "mapped", "thread": "worker1", "text": "Connection reset by peer"},
+			},
+			wantErr: false,
+		},
+		{
+			name:   "pass rewrite message with prefix",
+			fields: fields{messageField: "text", tagsPrefix: "parsed_"},
+			log:    api.Log{Message: message},
+			want: api.Log{
+				Message: "Connection reset by peer",
+				Tags:    map[string]string{"parsed_ip": "10.0.0.1", "parsed_thread": "worker1"},
+			},
+			wantErr: false,
+		},
+		{
+			name:    "pass ignore failure",
+			fields:  fields{ignoreFailure: true},
+			log:     api.Log{Message: "unstructured", Tags: map[string]string{}},
+			want:    api.Log{Message: "unstructured", Tags: map[string]string{}},
+			wantErr: false,
+		},
+		{
+			name:    "fail no match",
+			fields:  fields{},
+			log:     api.Log{Message: "unstructured"},
+			wantErr: true,
+		},
+		{
+			name:    "fail message field not extracted",
+			fields:  fields{messageField: "missing"},
+			log:     api.Log{Message: message},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mp := &MessageParser{
+				Parser:        regexParser,
+				MessageField:  tt.fields.messageField,
+				TagsPrefix:    tt.fields.tagsPrefix,
+				IgnoreFailure: tt.fields.ignoreFailure,
+			}
+			log := tt.log
+			err := mp.apply(&log)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("apply() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				assert.NotContains(t, err.Error(), tt.log.Message)
+			}
+			if !tt.wantErr {
+				assert.Equal(t, tt.want, log)
+			}
+		})
+	}
+}
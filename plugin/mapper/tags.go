@@ -0,0 +1,206 @@
+package mapper
+
+import (
+	"fmt"
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/common"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// compileGlob converts a glob pattern to a regular expression. A * matches any sequence of characters including
+// dots, a ? matches a single character.
+func compileGlob(glob string) (*regexp.Regexp, error) {
+	var expr strings.Builder
+	expr.WriteString("^")
+	for _, c := range glob {
+		switch c {
+		case '*':
+			expr.WriteString(".*")
+		case '?':
+			expr.WriteString(".")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr.WriteString("$")
+	compiled, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil, fmt.Errorf("%w; invalid glob pattern %v", err, glob)
+	}
+	return compiled, nil
+}
+
+func compileGlobs(globs []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, len(globs))
+	for i, glob := range globs {
+		expr, err := compileGlob(glob)
+		if err != nil {
+			return nil, err
+		}
+		compiled[i] = expr
+	}
+	return compiled, nil
+}
+
+func matchesAny(exprs []*regexp.Regexp, value string) bool {
+	for _, expr := range exprs {
+		if expr.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// TagPattern maps all fields whose keys match a glob pattern to tags. The tag name is the key relative to the object
+// which contains all matching fields, prepended by the TagPrefix. E.g. the pattern kubernetes.labels.* with the tag
+// prefix k8s_ maps kubernetes.labels.app to the tag k8s_app.
+type TagPattern struct {
+	Pattern   string
+	TagPrefix string
+
+	expr *regexp.Regexp
+	// root is the key of the object which contains all matching fields, i.e. the part of the pattern up to the last
+	// dot before the first wildcard. It is empty if there is no such dot.
+	root string
+}
+
+func NewTagPattern(pattern string, tagPrefix string) (*TagPattern, error) {
+	expr, err := compileGlob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	literalPrefix := pattern
+	if wildcard := strings.IndexAny(pattern, "*?"); wildcard >= 0 {
+		literalPrefix = pattern[:wildcard]
+	}
+	root := ""
+	if dot := strings.LastIndex(literalPrefix, "."); dot >= 0 {
+		root = literalPrefix[:dot]
+	}
+	return &TagPattern{Pattern: pattern, TagPrefix: tagPrefix, expr: expr, root: root}, nil
+}
+
+// fields returns the flattened fields of the event which can match the pattern. The keys are relative to the root.
+func (tp *TagPattern) fields(event beat.Event) common.MapStr {
+	if tp.root == "" {
+		return event.Fields.Flatten()
+	}
+	value, err := event.GetValue(tp.root)
+	if err != nil {
+		return nil
+	}
+	var object common.MapStr
+	switch v := value.(type) {
+	case common.MapStr:
+		object = v
+	case map[string]interface{}:
+		object = v
+	default:
+		return nil
+	}
+	return object.Flatten()
+}
+
+func (tp *TagPattern) matches(relativeKey string) bool {
+	if tp.root == "" {
+		return tp.expr.MatchString(relativeKey)
+	}
+	return tp.expr.MatchString(tp.root + "." + relativeKey)
+}
+
+// PatternTagsMapper maps fields to tags with TagPatterns. Tags are only added if their name matches one of the
+// Include globs (if any are set) and none of the Exclude globs. Values which are not strings are converted by the
+// Coercer. Without Coercer, they are dropped. MaxTags limits the total number of tags of a log if greater than 0,
+// including the tags which are not mapped by the patterns.
+type PatternTagsMapper struct {
+	Patterns []*TagPattern
+	Include  []*regexp.Regexp
+	Exclude  []*regexp.Regexp
+	MaxTags  int
+	Coercer  *TagCoercer
+}
+
+func NewPatternTagsMapper(patterns []*TagPattern, include []string, exclude []string, maxTags int) (*PatternTagsMapper, error) {
+	includeExprs, err := compileGlobs(include)
+	if err != nil {
+		return nil, err
+	}
+	excludeExprs, err := compileGlobs(exclude)
+	if err != nil {
+		return nil, err
+	}
+	return &PatternTagsMapper{Patterns: patterns, Include: includeExprs, Exclude: excludeExprs, MaxTags: maxTags}, nil
+}
+
+func (pm *PatternTagsMapper) DoMap(event beat.Event) (interface{}, error) {
+	values := make(map[string]interface{})
+	for _, pattern := range pm.Patterns {
+		for key, value := range pattern.fields(event) {
+			if !pattern.matches(key) {
+				continue
+			}
+			name := pattern.TagPrefix + key
+			if len(pm.Include) > 0 && !matchesAny(pm.Include, name) || matchesAny(pm.Exclude, name) {
+				continue
+			}
+			// The first pattern wins if several patterns result in the same tag
+			if _, exists := values[name]; !exists {
+				values[name] = value
+			}
+		}
+	}
+	return values, nil
+}
+
+// apply adds the tags of the patterns which do not exist yet. Tags beyond MaxTags are skipped in the order of their
+// names, so the same tags are kept for all events. The number of values which could not be converted to strings or
+// were skipped is returned.
+func (pm *PatternTagsMapper) apply(tags map[string]string, event beat.Event) int {
+	values, _ := pm.DoMap(event)
+	coerced := make(map[string]string)
+	dropped := 0
+	for name, value := range values.(map[string]interface{}) {
+		if pm.Coercer != nil {
+			if !pm.Coercer.coerce(name, value, coerced) {
+				dropped++
+			}
+		} else if str, ok := value.(string); ok {
+			coerced[name] = str
+		} else {
+			dropped++
+		}
+	}
+	var names []string
+	for name := range coerced {
+		if _, exists := tags[name]; !exists {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		if pm.MaxTags > 0 && len(tags) >= pm.MaxTags {
+			dropped += len(names) - i
+			break
+		}
+		tags[name] = coerced[name]
+	}
+	return dropped
+}
+
+// limit removes the tags beyond MaxTags in the order of their names and returns the number of removed tags
+func (pm *PatternTagsMapper) limit(tags map[string]string) int {
+	if pm.MaxTags <= 0 || len(tags) <= pm.MaxTags {
+		return 0
+	}
+	names := make([]string, 0, len(tags))
+	for name := range tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names[pm.MaxTags:] {
+		delete(tags, name)
+	}
+	return len(names) - pm.MaxTags
+}
@@ -0,0 +1,101 @@
+package mapper
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/elastic/beats/v7/libbeat/common"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Modes for the coercion of array and object tag values
+const (
+	ArrayModeJoin   = "join"
+	ArrayModeExpand = "expand"
+	ArrayModeDrop   = "drop"
+	ObjectModeJson  = "json"
+	ObjectModeDrop  = "drop"
+)
+
+// TagCoercer converts tag values which are not strings. Numbers, booleans and times are always formatted. Arrays are
+// joined with the ArraySeparator, expanded to one tag per element (named <tag>.<index>) or dropped. Objects are json
+// encoded or dropped.
+type TagCoercer struct {
+	ArrayMode      string
+	ArraySeparator string
+	ObjectMode     string
+}
+
+// DefaultTagCoercer joins arrays with commas and json encodes objects
+var DefaultTagCoercer = TagCoercer{ArrayMode: ArrayModeJoin, ArraySeparator: ",", ObjectMode: ObjectModeJson}
+
+// coerce adds the value as one or more string tags. It returns false if the value was dropped.
+func (tc *TagCoercer) coerce(name string, value interface{}, tags map[string]string) bool {
+	if str, ok := scalarString(value); ok {
+		tags[name] = str
+		return true
+	}
+	switch v := value.(type) {
+	case common.MapStr, map[string]interface{}, map[string]string:
+		if tc.ObjectMode != ObjectModeJson {
+			return false
+		}
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return false
+		}
+		tags[name] = string(encoded)
+		return true
+	}
+
+	array := reflect.ValueOf(value)
+	if value == nil || (array.Kind() != reflect.Slice && array.Kind() != reflect.Array) {
+		return false
+	}
+	elements := make([]string, array.Len())
+	for i := range elements {
+		element := array.Index(i).Interface()
+		if str, ok := scalarString(element); ok {
+			elements[i] = str
+		} else if encoded, err := json.Marshal(element); err == nil {
+			elements[i] = string(encoded)
+		} else {
+			return false
+		}
+	}
+	switch tc.ArrayMode {
+	case ArrayModeJoin:
+		tags[name] = strings.Join(elements, tc.ArraySeparator)
+	case ArrayModeExpand:
+		for i, element := range elements {
+			tags[fmt.Sprintf("%v.%v", name, i)] = element
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// scalarString formats strings, numbers, booleans and times
+func scalarString(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case bool:
+		return strconv.FormatBool(v), true
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, json.Number:
+		return fmt.Sprintf("%v", v), true
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32), true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case time.Time:
+		return v.Format(time.RFC3339Nano), true
+	case common.Time:
+		return time.Time(v).Format(time.RFC3339Nano), true
+	default:
+		return "", false
+	}
+}
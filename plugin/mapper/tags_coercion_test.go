@@ -0,0 +1,168 @@
+package mapper
+
+import (
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/common"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTagCoercer_coerce(t *testing.T) {
+	tests := []struct {
+		name    string
+		coercer TagCoercer
+		value   interface{}
+		want    map[string]string
+		wantOk  bool
+	}{
+		{
+			name:    "pass string",
+			coercer: DefaultTagCoercer,
+			value:   "value",
+			want:    map[string]string{"tag": "value"},
+			wantOk:  true,
+		},
+		{
+			name:    "pass int",
+			coercer: DefaultTagCoercer,
+			value:   int64(404),
+			want:    map[string]string{"tag": "404"},
+			wantOk:  true,
+		},
+		{
+			name:    "pass float",
+			coercer: DefaultTagCoercer,
+			value:   0.25,
+			want:    map[string]string{"tag": "0.25"},
+			wantOk:  true,
+		},
+		{
+			name:    "pass bool",
+			coercer: DefaultTagCoercer,
+			value:   true,
+			want:    map[string]string{"tag": "true"},
+			wantOk:  true,
+		},
+		{
+			name:    "pass time",
+			coercer: DefaultTagCoercer,
+			value:   time.Date(2022, 4, 4, 9, 0, 35, 0, time.UTC),
+			want:    map[string]string{"tag": "2022-04-04T09:00:35Z"},
+			wantOk:  true,
+		},
+		{
+			name:    "pass array join",
+			coercer: TagCoercer{ArrayMode: ArrayModeJoin, ArraySeparator: "|"},
+			value:   []interface{}{"a", 1, false},
+			want:    map[string]string{"tag": "a|1|false"},
+			wantOk:  true,
+		},
+		{
+			name:    "pass array expand",
+			coercer: TagCoercer{ArrayMode: ArrayModeExpand},
+			value:   []string{"a", "b"},
+			want:    map[string]string{"tag.0": "a", "tag.1": "b"},
+			wantOk:  true,
+		},
+		{
+			name:    "pass array of objects join",
+			coercer: DefaultTagCoercer,
+			value:   []interface{}{common.MapStr{"k": "v"}},
+			want:    map[string]string{"tag": `{"k":"v"}`},
+			wantOk:  true,
+		},
+		{
+			name:    "pass object json",
+			coercer: DefaultTagCoercer,
+			value:   common.MapStr{"b": 2, "a": "1"},
+			want:    map[string]string{"tag": `{"a":"1","b":2}`},
+			wantOk:  true,
+		},
+		{
+			name:    "fail array drop",
+			coercer: TagCoercer{ArrayMode: ArrayModeDrop, ObjectMode: ObjectModeJson},
+			value:   []string{"a"},
+			want:    map[string]string{},
+			wantOk:  false,
+		},
+		{
+			name:    "fail object drop",
+			coercer: TagCoercer{ArrayMode: ArrayModeJoin, ObjectMode: ObjectModeDrop},
+			value:   map[string]interface{}{"k": "v"},
+			want:    map[string]string{},
+			wantOk:  false,
+		},
+		{
+			name:    "fail nil",
+			coercer: DefaultTagCoercer,
+			value:   nil,
+			want:    map[string]string{},
+			wantOk:  false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tags := map[string]string{}
+			ok := tt.coercer.coerce("tag", tt.value, tags)
+			assert.Equal(t, tt.wantOk, ok)
+			assert.Equal(t, tt.want, tags)
+		})
+	}
+}
+
+func TestLogMapper_ToLog_droppedTags(t *testing.T) {
+	tagsMapping := map[string]string{"status": "http.status", "ids": "ids", "user": "user"}
+	event := beat.Event{Fields: common.MapStr{
+		"message": "request failed",
+		"http":    common.MapStr{"status": 500},
+		"ids":     []interface{}{"a", "b"},
+		"user":    common.MapStr{"name": "alice"},
+	}}
+	tests := []struct {
+		name        string
+		coercer     *TagCoercer
+		want        map[string]string
+		wantDropped uint64
+	}{
+		{
+			name:        "pass without coercer",
+			coercer:     nil,
+			want:        map[string]string{},
+			wantDropped: 3,
+		},
+		{
+			name:        "pass default coercer",
+			coercer:     &DefaultTagCoercer,
+			want:        map[string]string{"status": "500", "ids": "a,b", "user": `{"name":"alice"}`},
+			wantDropped: 0,
+		},
+		{
+			name:        "pass drop objects",
+			coercer:     &TagCoercer{ArrayMode: ArrayModeExpand, ObjectMode: ObjectModeDrop},
+			want:        map[string]string{"status": "500", "ids.0": "a", "ids.1": "b"},
+			wantDropped: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lm := &LogMapper{
+				TimestampMapper: &StringMapper{Mapper: EventTimeMapper{}},
+				MessageMapper:   &StringMapper{Mapper: KeyMapper{Key: "message"}},
+				LevelMapper:     &StringMapper{Mapper: ConstantStringMapper{ConstantString: "info"}},
+				TagsMapper: &MultipleKeyValueStringMapper{
+					Mapper:  MultipleKeyValueMapper{KeyValuePairs: tagsMapping},
+					Coercer: tt.coercer,
+				},
+			}
+			got, err := lm.ToLog(event)
+			if err != nil {
+				t.Fatalf("ToLog() error = %v", err)
+			}
+			assert.Equal(t, tt.want, got.Tags)
+			assert.Equal(t, tt.wantDropped, lm.TakeDroppedTags())
+			assert.Equal(t, uint64(0), lm.TakeDroppedTags())
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package mapper
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Names of the tags presets
+const (
+	TagsPresetKubernetes = "kubernetes"
+	TagsPresetDocker     = "docker"
+	TagsPresetCloud      = "cloud"
+	TagsPresetHost       = "host"
+)
+
+// tagsPresets map the ECS fields which are added by the metadata processors of filebeat (add_kubernetes_metadata,
+// add_docker_metadata, add_cloud_metadata and add_host_metadata) to tags. The keys are the tag names.
+var tagsPresets = map[string]map[string]string{
+	TagsPresetKubernetes: {
+		"namespace": "kubernetes.namespace",
+		"pod":       "kubernetes.pod.name",
+		"container": "kubernetes.container.name",
+		"image":     "container.image.name",
+		"node":      "kubernetes.node.name",
+	},
+	TagsPresetDocker: {
+		"container":    "container.name",
+		"container_id": "container.id",
+		"image":        "container.image.name",
+	},
+	TagsPresetCloud: {
+		"cloud_provider":          "cloud.provider",
+		"cloud_region":            "cloud.region",
+		"cloud_availability_zone": "cloud.availability_zone",
+		"cloud_instance_id":       "cloud.instance.id",
+	},
+	TagsPresetHost: {
+		"host": "host.name",
+		"os":   "host.os.name",
+	},
+}
+
+// TagsPresetNames returns the names of all tags presets in alphabetical order
+func TagsPresetNames() []string {
+	names := make([]string, 0, len(tagsPresets))
+	for name := range tagsPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// WithTagsPresets returns the tags mapping of the presets combined with the given tags mapping. The given mapping
+// takes precedence over the presets, and a preset takes precedence over the presets before it.
+func WithTagsPresets(presets []string, tagsMapping map[string]string) (map[string]string, error) {
+	combined := make(map[string]string)
+	for _, preset := range presets {
+		mapping, ok := tagsPresets[preset]
+		if !ok {
+			return nil, fmt.Errorf("unknown tags preset %v. must be one of %v", preset, TagsPresetNames())
+		}
+		for tag, key := range mapping {
+			combined[tag] = key
+		}
+	}
+	for tag, key := range tagsMapping {
+		combined[tag] = key
+	}
+	return combined, nil
+}
@@ -0,0 +1,59 @@
+package mapper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithTagsPresets(t *testing.T) {
+	tests := []struct {
+		name        string
+		presets     []string
+		tagsMapping map[string]string
+		want        map[string]string
+		wantErr     bool
+	}{
+		{
+			name:        "pass no presets",
+			presets:     nil,
+			tagsMapping: map[string]string{"service": "service.name"},
+			want:        map[string]string{"service": "service.name"},
+			wantErr:     false,
+		},
+		{
+			name:        "pass explicit mapping overrides preset",
+			presets:     []string{TagsPresetHost},
+			tagsMapping: map[string]string{"host": "agent.hostname", "service": "service.name"},
+			want:        map[string]string{"host": "agent.hostname", "os": "host.os.name", "service": "service.name"},
+			wantErr:     false,
+		},
+		{
+			name:    "pass later preset overrides earlier preset",
+			presets: []string{TagsPresetKubernetes, TagsPresetDocker},
+			want: map[string]string{
+				"namespace":    "kubernetes.namespace",
+				"pod":          "kubernetes.pod.name",
+				"container":    "container.name",
+				"container_id": "container.id",
+				"image":        "container.image.name",
+				"node":         "kubernetes.node.name",
+			},
+			wantErr: false,
+		},
+		{
+			name:    "fail unknown preset",
+			presets: []string{"openshift"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := WithTagsPresets(tt.presets, tt.tagsMapping)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("WithTagsPresets() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package mapper
+
+import (
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/common"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_compileGlob(t *testing.T) {
+	tests := []struct {
+		name  string
+		glob  string
+		value string
+		want  bool
+	}{
+		{name: "pass star matches dots", glob: "kubernetes.*", value: "kubernetes.labels.app", want: true},
+		{name: "pass question mark", glob: "k8s_?pp", value: "k8s_app", want: true},
+		{name: "pass literal dot", glob: "k8s.app", value: "k8s_app", want: false},
+		{name: "pass anchored", glob: "labels.*", value: "kubernetes.labels.app", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := compileGlob(tt.glob)
+			if err != nil {
+				t.Fatalf("compileGlob() error = %v", err)
+			}
+			if got := expr.MatchString(tt.value); got != tt.want {
+				t.Errorf("compileGlob() matches %v = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPatternTagsMapper_apply(t *testing.T) {
+	event := beat.Event{Fields: common.MapStr{
+		"kubernetes": common.MapStr{
+			"labels": common.MapStr{
+				"app":               "checkout",
+				"team":              "payments",
+				"pod-template-hash": "5d8f7c",
+				"app_kubernetes_io": common.MapStr{"version": "1.2.3"},
+			},
+			"namespace": "shop",
+		},
+		"custom_env":   "prod",
+		"custom_count": 3,
+	}}
+	newPattern := func(pattern string, prefix string) *TagPattern {
+		tp, err := NewTagPattern(pattern, prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tp
+	}
+	tests := []struct {
+		name     string
+		patterns []*TagPattern
+		include  []string
+		exclude  []string
+		maxTags  int
+		tags     map[string]string
+		want     map[string]string
+		// wantDropped are the values which could not be converted or were skipped
+		wantDropped int
+	}{
+		{
+			name:     "pass prefix",
+			patterns: []*TagPattern{newPattern("kubernetes.labels.*", "k8s_")},
+			tags:     map[string]string{},
+			want: map[string]string{
+				"k8s_app":                       "checkout",
+				"k8s_team":                      "payments",
+				"k8s_pod-template-hash":         "5d8f7c",
+				"k8s_app_kubernetes_io.version": "1.2.3",
+			},
+		},
+		{
+			name:        "pass root level pattern skips non-string values",
+			patterns:    []*TagPattern{newPattern("custom_*", "")},
+			tags:        map[string]string{},
+			want:        map[string]string{"custom_env": "prod"},
+			wantDropped: 1,
+		},
+		{
+			name:     "pass include and exclude",
+			patterns: []*TagPattern{newPattern("kubernetes.labels.*", "k8s_")},
+			include:  []string{"k8s_*"},
+			exclude:  []string{"k8s_pod-template-hash", "k8s_*.*"},
+			tags:     map[string]string{},
+			want:     map[string]string{"k8s_app": "checkout", "k8s_team": "payments"},
+		},
+		{
+			name:     "pass existing tags take precedence",
+			patterns: []*TagPattern{newPattern("kubernetes.labels.a*", "")},
+			tags:     map[string]string{"app": "explicit"},
+			want:     map[string]string{"app": "explicit", "app_kubernetes_io.version": "1.2.3"},
+		},
+		{
+			name:        "pass max tags",
+			patterns:    []*TagPattern{newPattern("kubernetes.labels.*", "k8s_")},
+			maxTags:     3,
+			tags:        map[string]string{"host": "node-1"},
+			want:        map[string]string{"host": "node-1", "k8s_app": "checkout", "k8s_app_kubernetes_io.version": "1.2.3"},
+			wantDropped: 2,
+		},
+		{
+			name:     "pass missing root",
+			patterns: []*TagPattern{newPattern("docker.container.labels.*", "")},
+			tags:     map[string]string{},
+			want:     map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pm, err := NewPatternTagsMapper(tt.patterns, tt.include, tt.exclude, tt.maxTags)
+			if err != nil {
+				t.Fatalf("NewPatternTagsMapper() error = %v", err)
+			}
+			dropped := pm.apply(tt.tags, event)
+			assert.Equal(t, tt.want, tt.tags)
+			assert.Equal(t, tt.wantDropped, dropped)
+		})
+	}
+}
+
+func TestPatternTagsMapper_limit(t *testing.T) {
+	pm, err := NewPatternTagsMapper(nil, nil, nil, 2)
+	if err != nil {
+		t.Fatalf("NewPatternTagsMapper() error = %v", err)
+	}
+	tags := map[string]string{"pid": "1", "host": "node-1", "app": "shop"}
+	assert.Equal(t, 1, pm.limit(tags))
+	assert.Equal(t, map[string]string{"app": "shop", "host": "node-1"}, tags)
+	assert.Equal(t, 0, pm.limit(tags))
+}
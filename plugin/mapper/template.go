@@ -0,0 +1,30 @@
+package mapper
+
+import (
+	"fmt"
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/common/fmtstr"
+)
+
+// TemplateMapper renders a format string with fields of the event, e.g.
+// %{[http.request.method]} %{[url.path]} %{[http.response.status_code]}. Fields can have a default value like
+// %{[url.path]:-}. DoMap fails if a field without default value is missing.
+type TemplateMapper struct {
+	Template *fmtstr.EventFormatString
+}
+
+func NewTemplateMapper(template string) (*TemplateMapper, error) {
+	compiled, err := fmtstr.CompileEvent(template)
+	if err != nil {
+		return nil, fmt.Errorf("%w; invalid template %v", err, template)
+	}
+	return &TemplateMapper{Template: compiled}, nil
+}
+
+func (tm TemplateMapper) DoMap(event beat.Event) (interface{}, error) {
+	rendered, err := tm.Template.Run(&event)
+	if err != nil {
+		return "", fmt.Errorf("%w; failed to render template %v", err, tm.Template)
+	}
+	return rendered, nil
+}
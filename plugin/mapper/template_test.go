@@ -0,0 +1,77 @@
+package mapper
+
+import (
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/common"
+	"testing"
+)
+
+func TestNewTemplateMapper(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		wantErr  bool
+	}{
+		{name: "pass fields", template: "%{[http.request.method]} %{[url.path]}", wantErr: false},
+		{name: "pass constant", template: "request", wantErr: false},
+		{name: "fail unterminated field", template: "%{[url.path]", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewTemplateMapper(tt.template); (err != nil) != tt.wantErr {
+				t.Errorf("NewTemplateMapper() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTemplateMapper_DoMap(t *testing.T) {
+	testEvent := beat.Event{Fields: common.MapStr{
+		"http": common.MapStr{
+			"request":  common.MapStr{"method": "GET"},
+			"response": common.MapStr{"status_code": 404},
+		},
+		"url": common.MapStr{"path": "/index.html"},
+	}}
+	tests := []struct {
+		name     string
+		template string
+		want     interface{}
+		wantErr  bool
+	}{
+		{
+			name:     "pass",
+			template: "%{[http.request.method]} %{[url.path]} %{[http.response.status_code]}",
+			want:     "GET /index.html 404",
+			wantErr:  false,
+		},
+		{
+			name:     "pass default of missing field",
+			template: "%{[http.request.method]} %{[url.query]:-}",
+			want:     "GET -",
+			wantErr:  false,
+		},
+		{
+			name:     "fail missing field",
+			template: "%{[http.request.method]} %{[url.query]}",
+			want:     "",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tm, err := NewTemplateMapper(tt.template)
+			if err != nil {
+				t.Fatalf("NewTemplateMapper() error = %v", err)
+			}
+			got, err := tm.DoMap(testEvent)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("DoMap() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("DoMap() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package plugin
+
+import (
+	"github.com/aiops/logsight-filebeat/plugin/processor"
+	"github.com/elastic/beats/v7/libbeat/monitoring"
+	"sync"
+)
+
+// processorMetrics reports the counters of the processors of all clients in the monitoring of the beat, e.g. as
+// logsight.processors.dedup.suppressed or logsight.processors.redaction.<rule>. The counters of closed clients are
+// kept, so the reported values only increase.
+var processorMetrics = newMetricsReporter()
+
+func init() {
+	registry := monitoring.Default.NewRegistry("logsight")
+	monitoring.NewFunc(registry, "processors", processorMetrics.report, monitoring.Report)
+}
+
+type metricsReporter struct {
+	mutex   sync.Mutex
+	clients map[*Client]bool
+	closed  map[string]map[string]uint64
+}
+
+func newMetricsReporter() *metricsReporter {
+	return &metricsReporter{clients: map[*Client]bool{}, closed: map[string]map[string]uint64{}}
+}
+
+// add reports the counters of the client. A client which is connected again after it was closed takes its counters
+// back from the closed ones, so they are not reported twice.
+func (mr *metricsReporter) add(c *Client) {
+	mr.mutex.Lock()
+	defer mr.mutex.Unlock()
+	if mr.clients[c] {
+		return
+	}
+	mr.clients[c] = true
+	subtractCounts(mr.closed, c.closedCounts)
+	c.closedCounts = nil
+}
+
+// remove keeps the counters of the client, since they must not decrease
+func (mr *metricsReporter) remove(c *Client) {
+	mr.mutex.Lock()
+	defer mr.mutex.Unlock()
+	if !mr.clients[c] {
+		return
+	}
+	delete(mr.clients, c)
+	c.closedCounts = processorCounts(c.processors)
+	addCounts(mr.closed, c.closedCounts)
+}
+
+// counts returns the counters of all processors by processor and name
+func (mr *metricsReporter) counts() map[string]map[string]uint64 {
+	mr.mutex.Lock()
+	defer mr.mutex.Unlock()
+	counts := map[string]map[string]uint64{}
+	addCounts(counts, mr.closed)
+	for c := range mr.clients {
+		addCounts(counts, processorCounts(c.processors))
+	}
+	return counts
+}
+
+func (mr *metricsReporter) report(_ monitoring.Mode, V monitoring.Visitor) {
+	V.OnRegistryStart()
+	defer V.OnRegistryFinished()
+	for group, values := range mr.counts() {
+		values := values
+		monitoring.ReportNamespace(V, group, func() {
+			for name, value := range values {
+				monitoring.ReportInt(V, name, int64(value))
+			}
+		})
+	}
+}
+
+func processorCounts(processors processor.Pipeline) map[string]map[string]uint64 {
+	counts := map[string]map[string]uint64{}
+	for _, p := range processors {
+		switch p := p.(type) {
+		case *processor.Deduplicator:
+			addCounts(counts, map[string]map[string]uint64{"dedup": p.Metrics()})
+		case *processor.Redactor:
+			addCounts(counts, map[string]map[string]uint64{"redaction": p.Counts()})
+		}
+	}
+	return counts
+}
+
+func addCounts(counts map[string]map[string]uint64, added map[string]map[string]uint64) {
+	for group, values := range added {
+		if counts[group] == nil {
+			counts[group] = map[string]uint64{}
+		}
+		for name, value := range values {
+			counts[group][name] += value
+		}
+	}
+}
+
+func subtractCounts(counts map[string]map[string]uint64, subtracted map[string]map[string]uint64) {
+	for group, values := range subtracted {
+		for name, value := range values {
+			counts[group][name] -= value
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package plugin
+
+import (
+	"github.com/aiops/logsight-filebeat/plugin/api"
+	"github.com/aiops/logsight-filebeat/plugin/api/apitest"
+	"github.com/aiops/logsight-filebeat/plugin/processor"
+	"github.com/elastic/beats/v7/libbeat/monitoring"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestMetricsReporter(t *testing.T) {
+	dedup := processor.NewDeduplicator(time.Minute, 10, "application")
+	client := &Client{processors: processor.Pipeline{dedup}}
+	reporter := newMetricsReporter()
+	reporter.add(client)
+
+	log := &api.Log{Timestamp: "2022-04-04T09:00:00Z", Message: "crash", Level: "ERROR"}
+	dedup.Process([]*api.Log{log, log, log})
+	assert.Equal(t, uint64(2), reporter.counts()["dedup"]["suppressed"])
+
+	// The counters of a closed client are still reported
+	reporter.remove(client)
+	reporter.add(&Client{processors: processor.Pipeline{processor.NewDeduplicator(time.Minute, 10, "application")}})
+	assert.Equal(t, uint64(2), reporter.counts()["dedup"]["suppressed"])
+
+	// A client which is connected again after it was closed is reported once and keeps updating its counters
+	reporter.add(client)
+	assert.Equal(t, uint64(2), reporter.counts()["dedup"]["suppressed"])
+	dedup.Process([]*api.Log{log})
+	assert.Equal(t, uint64(3), reporter.counts()["dedup"]["suppressed"])
+
+	detector, _ := processor.NewDetector("email")
+	redactor := &processor.Redactor{Rules: []*processor.RedactionRule{{Name: "email", Detector: detector}}}
+	reporter.add(&Client{processors: processor.Pipeline{redactor}})
+	redactor.Process([]*api.Log{{Message: "login of jane@example.com"}})
+	assert.Equal(t, uint64(1), reporter.counts()["redaction"]["email"])
+
+	registry := monitoring.NewRegistry()
+	monitoring.NewFunc(registry, "processors", reporter.report, monitoring.Report)
+	snapshot := monitoring.CollectFlatSnapshot(registry, monitoring.Full, false)
+	assert.Equal(t, int64(3), snapshot.Ints["processors.dedup.suppressed"])
+	assert.Equal(t, int64(1), snapshot.Ints["processors.redaction.email"])
+}
+
+func TestClient_Connect_reportsMetricsAgain(t *testing.T) {
+	server := apitest.NewServer()
+	defer server.Close()
+	client := newTestClient(t, server, func(config *logsightConfig) {
+		config.Dedup.Enabled = true
+	})
+	_ = client.Connect()
+	// The pipeline closes the client after a failed publish and connects it again
+	_ = client.Close()
+	_ = client.Connect()
+	defer func() { _ = client.Close() }()
+	processorMetrics.mutex.Lock()
+	defer processorMetrics.mutex.Unlock()
+	assert.True(t, processorMetrics.clients[client])
+}
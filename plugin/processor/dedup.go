@@ -0,0 +1,153 @@
+package processor
+
+import (
+	"container/list"
+	"fmt"
+	"github.com/aiops/logsight-filebeat/plugin/api"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Tags of the logs which collapse repeated logs
+const (
+	RepeatCountTag    = "repeat_count"
+	FirstTimestampTag = "first_timestamp"
+	LastTimestampTag  = "last_timestamp"
+)
+
+// variableParts are replaced when a message is normalized, so that messages which differ only in ids, numbers or
+// addresses are considered repeats
+var variableParts = regexp.MustCompile(
+	`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}|0x[0-9a-fA-F]+|\d+`)
+
+func normalizeMessage(message string) string {
+	normalized := variableParts.ReplaceAllString(message, "#")
+	return strings.Join(strings.Fields(normalized), " ")
+}
+
+// repeated is a log which was sent and the repeats of it which were suppressed since
+type repeated struct {
+	key       string
+	log       *api.Log
+	firstSeen time.Time
+	repeats   int
+	last      string
+}
+
+// Deduplicator suppresses logs which repeat a log of the same application, level and normalized message within the
+// Window. The first log is sent immediately. Once the window has passed, the repeats are collapsed into one log with
+// the tags repeat_count, first_timestamp and last_timestamp, either when the next logs are processed or when they are
+// flushed. At most MaxEntries logs are tracked. If more logs are
+// seen, the least recently repeated logs are collapsed early.
+type Deduplicator struct {
+	Window         time.Duration
+	MaxEntries     int
+	ApplicationTag string
+
+	mutex   sync.Mutex
+	entries map[string]*list.Element
+	// lru holds the entries ordered by their last repeat, the least recent first
+	lru *list.List
+	now func() time.Time
+
+	suppressed uint64
+	collapsed  uint64
+	evicted    uint64
+}
+
+func NewDeduplicator(window time.Duration, maxEntries int, applicationTag string) *Deduplicator {
+	return &Deduplicator{
+		Window:         window,
+		MaxEntries:     maxEntries,
+		ApplicationTag: applicationTag,
+		entries:        map[string]*list.Element{},
+		lru:            list.New(),
+		now:            time.Now,
+	}
+}
+
+func (d *Deduplicator) Process(logs []*api.Log) []*api.Log {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	now := d.now()
+	kept := d.expire(now, false, nil)
+	for _, log := range logs {
+		key := strings.Join([]string{log.Tags[d.ApplicationTag], log.Level, normalizeMessage(log.Message)}, "\x00")
+		if element, exists := d.entries[key]; exists {
+			entry := element.Value.(*repeated)
+			entry.repeats++
+			entry.last = log.Timestamp
+			d.lru.MoveToBack(element)
+			atomic.AddUint64(&d.suppressed, 1)
+			continue
+		}
+		if d.MaxEntries > 0 && d.lru.Len() >= d.MaxEntries {
+			kept = d.remove(d.lru.Front(), kept)
+			atomic.AddUint64(&d.evicted, 1)
+		}
+		d.entries[key] = d.lru.PushBack(&repeated{key: key, log: log, firstSeen: now, last: log.Timestamp})
+		kept = append(kept, log)
+	}
+	return kept
+}
+
+// Flush returns the collapsed logs of the entries whose window passed, or of all entries if final is set
+func (d *Deduplicator) Flush(final bool) []*api.Log {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.expire(d.now(), final, nil)
+}
+
+// expire removes the entries whose window passed, or all entries if all is set, and appends their collapsed logs
+func (d *Deduplicator) expire(now time.Time, all bool, logs []*api.Log) []*api.Log {
+	for element := d.lru.Front(); element != nil; {
+		next := element.Next()
+		if entry := element.Value.(*repeated); all || now.Sub(entry.firstSeen) >= d.Window {
+			logs = d.remove(element, logs)
+		}
+		element = next
+	}
+	return logs
+}
+
+// remove stops tracking the entry and appends the collapsed log if the entry was repeated
+func (d *Deduplicator) remove(element *list.Element, logs []*api.Log) []*api.Log {
+	entry := d.lru.Remove(element).(*repeated)
+	delete(d.entries, entry.key)
+	if entry.repeats == 0 {
+		return logs
+	}
+	tags := make(map[string]string, len(entry.log.Tags)+3)
+	for name, value := range entry.log.Tags {
+		tags[name] = value
+	}
+	tags[RepeatCountTag] = strconv.Itoa(entry.repeats)
+	tags[FirstTimestampTag] = entry.log.Timestamp
+	tags[LastTimestampTag] = entry.last
+	atomic.AddUint64(&d.collapsed, 1)
+	return append(logs, &api.Log{
+		Timestamp: entry.last,
+		Message:   entry.log.Message,
+		Level:     entry.log.Level,
+		Tags:      tags,
+	})
+}
+
+// Metrics returns the number of suppressed repeats, of the logs which collapsed them and of the entries which were
+// evicted before their window passed
+func (d *Deduplicator) Metrics() map[string]uint64 {
+	return map[string]uint64{
+		"suppressed": atomic.LoadUint64(&d.suppressed),
+		"collapsed":  atomic.LoadUint64(&d.collapsed),
+		"evicted":    atomic.LoadUint64(&d.evicted),
+	}
+}
+
+func (d *Deduplicator) String() string {
+	return fmt.Sprintf("deduplicator with window %v and at most %v entries", d.Window, d.MaxEntries)
+}
@@ -0,0 +1,121 @@
+package processor
+
+import (
+	"github.com/aiops/logsight-filebeat/plugin/api"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func dedupLog(timestamp string, message string) *api.Log {
+	return &api.Log{
+		Timestamp: timestamp,
+		Message:   message,
+		Level:     "ERROR",
+		Tags:      map[string]string{"application": "shop"},
+	}
+}
+
+func Test_normalizeMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    string
+	}{
+		{name: "pass numbers", message: "retry 3 of 10 failed", want: "retry # of # failed"},
+		{name: "pass uuid", message: "order 0b5f6d4e-2b1c-4a53-9d3e-8f1a2b3c4d5e failed", want: "order # failed"},
+		{name: "pass hex and whitespace", message: "segfault  at 0x7ffd\t", want: "segfault at #"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, normalizeMessage(tt.message))
+		})
+	}
+}
+
+func TestDeduplicator_Process(t *testing.T) {
+	now := time.Date(2022, 4, 4, 9, 0, 0, 0, time.UTC)
+	dedup := NewDeduplicator(time.Minute, 10, "application")
+	dedup.now = func() time.Time { return now }
+
+	first := dedupLog("2022-04-04T09:00:00Z", "connection to 10.0.0.1 failed")
+	kept := dedup.Process([]*api.Log{
+		first,
+		dedupLog("2022-04-04T09:00:01Z", "connection to 10.0.0.2 failed"),
+		dedupLog("2022-04-04T09:00:02Z", "started"),
+	})
+	assert.Len(t, kept, 2)
+
+	now = now.Add(30 * time.Second)
+	kept = dedup.Process([]*api.Log{dedupLog("2022-04-04T09:00:30Z", "connection to 10.0.0.3 failed")})
+	assert.Len(t, kept, 0)
+
+	// The window has passed, so the repeats are collapsed and the next log is sent again
+	now = now.Add(30 * time.Second)
+	kept = dedup.Process([]*api.Log{dedupLog("2022-04-04T09:01:00Z", "connection to 10.0.0.4 failed")})
+	want := []*api.Log{
+		{
+			Timestamp: "2022-04-04T09:00:30Z",
+			Message:   "connection to 10.0.0.1 failed",
+			Level:     "ERROR",
+			Tags: map[string]string{
+				"application":     "shop",
+				RepeatCountTag:    "2",
+				FirstTimestampTag: "2022-04-04T09:00:00Z",
+				LastTimestampTag:  "2022-04-04T09:00:30Z",
+			},
+		},
+		dedupLog("2022-04-04T09:01:00Z", "connection to 10.0.0.4 failed"),
+	}
+	assert.Equal(t, want, kept)
+	assert.Equal(t, map[string]string{"application": "shop"}, first.Tags, "the first log must not be modified")
+	assert.Equal(t, map[string]uint64{"suppressed": 2, "collapsed": 1, "evicted": 0}, dedup.Metrics())
+}
+
+func TestDeduplicator_Process_evict(t *testing.T) {
+	dedup := NewDeduplicator(time.Minute, 2, "application")
+	kept := dedup.Process([]*api.Log{
+		dedupLog("2022-04-04T09:00:00Z", "a"),
+		dedupLog("2022-04-04T09:00:01Z", "b"),
+		dedupLog("2022-04-04T09:00:02Z", "a"),
+		// c evicts b, which is the least recently repeated entry
+		dedupLog("2022-04-04T09:00:03Z", "c"),
+		// b is sent again, it evicts a whose repeat is collapsed
+		dedupLog("2022-04-04T09:00:04Z", "b"),
+	})
+	var messages []string
+	for _, log := range kept {
+		messages = append(messages, log.Message+log.Tags[RepeatCountTag])
+	}
+	assert.Equal(t, []string{"a", "b", "c", "a1", "b"}, messages)
+	assert.Equal(t, uint64(2), dedup.Metrics()["evicted"])
+}
+
+func TestDeduplicator_Flush(t *testing.T) {
+	now := time.Date(2022, 4, 4, 9, 0, 0, 0, time.UTC)
+	dedup := NewDeduplicator(time.Minute, 10, "application")
+	dedup.now = func() time.Time { return now }
+	dedup.Process([]*api.Log{
+		dedupLog("2022-04-04T09:00:00Z", "crash"),
+		dedupLog("2022-04-04T09:00:01Z", "crash"),
+	})
+	now = now.Add(10 * time.Second)
+	dedup.Process([]*api.Log{dedupLog("2022-04-04T09:00:10Z", "restart"), dedupLog("2022-04-04T09:00:11Z", "restart")})
+
+	assert.Len(t, dedup.Flush(false), 0, "the windows have not passed yet")
+
+	// The crash loop went quiet, its repeats are flushed without further logs
+	now = now.Add(50 * time.Second)
+	flushed := dedup.Flush(false)
+	if assert.Len(t, flushed, 1) {
+		assert.Equal(t, "crash", flushed[0].Message)
+		assert.Equal(t, "1", flushed[0].Tags[RepeatCountTag])
+	}
+
+	flushed = dedup.Flush(true)
+	if assert.Len(t, flushed, 1) {
+		assert.Equal(t, "restart", flushed[0].Message)
+	}
+	assert.Len(t, dedup.Flush(true), 0)
+}
@@ -0,0 +1,105 @@
+package processor
+
+import (
+	"fmt"
+	"github.com/aiops/logsight-filebeat/plugin/api"
+	"regexp"
+	"strings"
+	"sync/atomic"
+)
+
+// FilterRule matches logs by their level, message and tags. A log matches if its level is one of the Levels, its
+// message matches one of the Messages and all Tags have the given values. Criteria which are not set match all logs.
+type FilterRule struct {
+	Levels   map[string]bool
+	Messages []*regexp.Regexp
+	Tags     map[string]string
+}
+
+func NewFilterRule(levels []string, messages []string, tags map[string]string) (*FilterRule, error) {
+	rule := &FilterRule{Tags: tags}
+	if len(levels) > 0 {
+		rule.Levels = make(map[string]bool, len(levels))
+		for _, level := range levels {
+			rule.Levels[strings.ToUpper(level)] = true
+		}
+	}
+	for _, message := range messages {
+		expr, err := regexp.Compile(message)
+		if err != nil {
+			return nil, fmt.Errorf("%w; invalid regex expression %v", err, message)
+		}
+		rule.Messages = append(rule.Messages, expr)
+	}
+	return rule, nil
+}
+
+func (fr *FilterRule) matches(log *api.Log) bool {
+	if fr.Levels != nil && !fr.Levels[log.Level] {
+		return false
+	}
+	if len(fr.Messages) > 0 {
+		matched := false
+		for _, expr := range fr.Messages {
+			if expr.MatchString(log.Message) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	for name, value := range fr.Tags {
+		if actual, ok := log.Tags[name]; !ok || actual != value {
+			return false
+		}
+	}
+	return true
+}
+
+// Filter keeps the logs which match one of the Include rules, if any are set, and none of the Exclude rules
+type Filter struct {
+	Include []*FilterRule
+	Exclude []*FilterRule
+
+	dropped uint64
+}
+
+func (f *Filter) Process(logs []*api.Log) []*api.Log {
+	var kept []*api.Log
+	for _, log := range logs {
+		if f.keep(log) {
+			kept = append(kept, log)
+		}
+	}
+	if dropped := len(logs) - len(kept); dropped > 0 {
+		atomic.AddUint64(&f.dropped, uint64(dropped))
+	}
+	return kept
+}
+
+func (f *Filter) keep(log *api.Log) bool {
+	if len(f.Include) > 0 && !anyMatches(f.Include, log) {
+		return false
+	}
+	return !anyMatches(f.Exclude, log)
+}
+
+func anyMatches(rules []*FilterRule, log *api.Log) bool {
+	for _, rule := range rules {
+		if rule.matches(log) {
+			return true
+		}
+	}
+	return false
+}
+
+// TakeDropped returns the number of logs which were dropped since the last call
+func (f *Filter) TakeDropped() int {
+	return int(atomic.SwapUint64(&f.dropped, 0))
+}
+
+func (f *Filter) String() string {
+	return fmt.Sprintf("filter with %v include and %v exclude rules", len(f.Include), len(f.Exclude))
+}
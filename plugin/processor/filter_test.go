@@ -0,0 +1,69 @@
+package processor
+
+import (
+	"github.com/aiops/logsight-filebeat/plugin/api"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilter_Process(t *testing.T) {
+	debug, _ := NewFilterRule([]string{"debug"}, nil, nil)
+	healthCheck, err := NewFilterRule(nil, []string{`^GET /health`, `kube-probe`}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prod, _ := NewFilterRule(nil, nil, map[string]string{"env": "prod"})
+	prodErrors, _ := NewFilterRule([]string{"ERROR"}, nil, map[string]string{"env": "prod"})
+
+	logs := []*api.Log{
+		{Message: "GET /healthz 200", Level: "INFO", Tags: map[string]string{"env": "prod"}},
+		{Message: "cache miss", Level: "DEBUG", Tags: map[string]string{"env": "prod"}},
+		{Message: "order placed", Level: "INFO", Tags: map[string]string{"env": "prod"}},
+		{Message: "order failed", Level: "ERROR", Tags: map[string]string{"env": "test"}},
+		{Message: "payment failed", Level: "ERROR", Tags: map[string]string{"env": "prod"}},
+	}
+	tests := []struct {
+		name   string
+		filter *Filter
+		want   []string
+	}{
+		{
+			name:   "pass exclude",
+			filter: &Filter{Exclude: []*FilterRule{debug, healthCheck}},
+			want:   []string{"order placed", "order failed", "payment failed"},
+		},
+		{
+			name:   "pass include",
+			filter: &Filter{Include: []*FilterRule{prod}},
+			want:   []string{"GET /healthz 200", "cache miss", "order placed", "payment failed"},
+		},
+		{
+			name:   "pass include and exclude",
+			filter: &Filter{Include: []*FilterRule{prod}, Exclude: []*FilterRule{debug, healthCheck}},
+			want:   []string{"order placed", "payment failed"},
+		},
+		{
+			name:   "pass all criteria of a rule must match",
+			filter: &Filter{Include: []*FilterRule{prodErrors}},
+			want:   []string{"payment failed"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var messages []string
+			for _, log := range tt.filter.Process(logs) {
+				messages = append(messages, log.Message)
+			}
+			assert.Equal(t, tt.want, messages)
+			assert.Equal(t, len(logs)-len(tt.want), tt.filter.TakeDropped())
+			assert.Equal(t, 0, tt.filter.TakeDropped())
+		})
+	}
+}
+
+func TestNewFilterRule_invalidRegex(t *testing.T) {
+	if _, err := NewFilterRule(nil, []string{"("}, nil); err == nil {
+		t.Errorf("NewFilterRule() expected error for invalid regex")
+	}
+}
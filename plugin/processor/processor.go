@@ -0,0 +1,39 @@
+// Package processor contains the stages which process the mapped logs of a batch before they are sent to logsight.
+package processor
+
+import (
+	"github.com/aiops/logsight-filebeat/plugin/api"
+)
+
+// Processor processes the mapped logs of a batch. It may modify the logs and returns the logs which are sent.
+type Processor interface {
+	Process(logs []*api.Log) []*api.Log
+	String() string
+}
+
+// Flusher is a Processor which holds back logs, e.g. collapsed repeats or summaries. Flush returns the logs which are
+// due, or all held back logs if final is set.
+type Flusher interface {
+	Flush(final bool) []*api.Log
+}
+
+// Pipeline applies the processors in order
+type Pipeline []Processor
+
+func (p Pipeline) Process(logs []*api.Log) []*api.Log {
+	for _, processor := range p {
+		logs = processor.Process(logs)
+	}
+	return logs
+}
+
+// Flush returns the held back logs of all Flushers. The logs of a Flusher are processed by the processors after it.
+func (p Pipeline) Flush(final bool) []*api.Log {
+	var flushed []*api.Log
+	for i, processor := range p {
+		if flusher, ok := processor.(Flusher); ok {
+			flushed = append(flushed, p[i+1:].Process(flusher.Flush(final))...)
+		}
+	}
+	return flushed
+}
@@ -0,0 +1,44 @@
+package processor
+
+import (
+	"github.com/aiops/logsight-filebeat/plugin/api"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// heldBack holds back all logs until they are flushed
+type heldBack struct {
+	logs []*api.Log
+}
+
+func (h *heldBack) Process(logs []*api.Log) []*api.Log {
+	h.logs = append(h.logs, logs...)
+	return nil
+}
+
+func (h *heldBack) Flush(bool) []*api.Log {
+	logs := h.logs
+	h.logs = nil
+	return logs
+}
+
+func (h *heldBack) String() string {
+	return "held back"
+}
+
+func TestPipeline_Flush(t *testing.T) {
+	rule, err := NewFilterRule(nil, []string{"^debug"}, nil)
+	if err != nil {
+		t.Fatalf("NewFilterRule() error = %v", err)
+	}
+	filter := &Filter{Exclude: []*FilterRule{rule}}
+	first, second := &heldBack{}, &heldBack{}
+	pipeline := Pipeline{first, filter, second}
+
+	assert.Len(t, pipeline.Process([]*api.Log{{Message: "debug"}, {Message: "started"}}), 0)
+
+	// The logs flushed by the first processor are filtered and held back by the second one
+	flushed := pipeline.Flush(true)
+	assert.Equal(t, []*api.Log{{Message: "started"}}, flushed)
+	assert.Len(t, pipeline.Flush(true), 0)
+}
@@ -0,0 +1,239 @@
+package processor
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/aiops/logsight-filebeat/plugin/api"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"unicode"
+)
+
+// Actions of redaction rules
+const (
+	ActionMask = "mask"
+	// ActionHash replaces values with an unkeyed hash, so the same value results in the same token everywhere. It is
+	// not privacy-safe: values of small or structured domains, e.g. ip addresses, card numbers or emails, can be
+	// recovered by hashing all candidates. ActionPseudonymize should be used instead.
+	ActionHash = "hash"
+	ActionDrop = "drop"
+	// ActionPseudonymize replaces values with a keyed hash, so the same value results in the same token on all
+	// hosts which share the key, while the value cannot be recovered without the key
+	ActionPseudonymize = "pseudonymize"
+)
+
+// DefaultMask replaces the redacted values of the mask action
+const DefaultMask = "[REDACTED]"
+
+// valueGroup is the name of the capture group which limits the redaction to a part of the match
+const valueGroup = "value"
+
+// Detector finds sensitive values with a regular expression. If the expression has a capture group named value, only
+// the group is redacted. Validate is optional and rejects false positives of the expression.
+type Detector struct {
+	Expr     *regexp.Regexp
+	Validate func(value string) bool
+}
+
+var detectors = map[string]*Detector{
+	"email": {Expr: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)},
+	"ipv4": {Expr: regexp.MustCompile(
+		`\b(?:(?:25[0-5]|2[0-4]\d|1\d\d|[1-9]?\d)\.){3}(?:25[0-5]|2[0-4]\d|1\d\d|[1-9]?\d)\b`)},
+	"ipv6":        {Expr: regexp.MustCompile(`[0-9A-Fa-f]{0,4}(?::[0-9A-Fa-f]{0,4}){2,7}`), Validate: isIPv6},
+	"credit_card": {Expr: regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`), Validate: isLuhnValid},
+	"jwt":         {Expr: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)},
+	"aws_key":     {Expr: regexp.MustCompile(`\b(?:AKIA|ASIA|AGPA|AIDA|AROA|ANPA|ANVA)[0-9A-Z]{16}\b`)},
+	"bearer_token": {Expr: regexp.MustCompile(
+		`(?i)\bbearer\s+(?P<value>[A-Za-z0-9\-._~+/]+=*)`)},
+}
+
+// DetectorNames returns the names of the built-in detectors in alphabetical order
+func DetectorNames() []string {
+	names := make([]string, 0, len(detectors))
+	for name := range detectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewDetector returns the built-in detector with the name
+func NewDetector(name string) (*Detector, error) {
+	detector, ok := detectors[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown detector %v. must be one of %v", name, DetectorNames())
+	}
+	return detector, nil
+}
+
+// NewRegexDetector returns a detector for a custom regular expression
+func NewRegexDetector(expr string) (*Detector, error) {
+	compiled, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("%w; invalid regex expression %v", err, expr)
+	}
+	return &Detector{Expr: compiled}, nil
+}
+
+func isIPv6(value string) bool {
+	return strings.Count(value, ":") >= 2 && net.ParseIP(value) != nil
+}
+
+// isLuhnValid checks the digits of a credit card number, ignoring spaces and dashes
+func isLuhnValid(value string) bool {
+	sum := 0
+	digits := 0
+	for i := len(value) - 1; i >= 0; i-- {
+		c := value[i]
+		if c == ' ' || c == '-' {
+			continue
+		}
+		d := int(c - '0')
+		if digits%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		digits++
+	}
+	return digits >= 13 && digits <= 19 && sum%10 == 0
+}
+
+// RedactionRule replaces the values found by its Detector according to its Action
+type RedactionRule struct {
+	Name     string
+	Detector *Detector
+	Action   string
+	// Mask replaces the values of the mask action
+	Mask string
+	// Key is the secret of the pseudonymize action
+	Key []byte
+
+	count uint64
+}
+
+// Count returns the number of values which were redacted by the rule
+func (rr *RedactionRule) Count() uint64 {
+	return atomic.LoadUint64(&rr.count)
+}
+
+func (rr *RedactionRule) replacement(value string) string {
+	switch rr.Action {
+	case ActionHash:
+		hash := sha256.Sum256([]byte(value))
+		return "sha256:" + hex.EncodeToString(hash[:8])
+	case ActionPseudonymize:
+		mac := hmac.New(sha256.New, rr.Key)
+		mac.Write([]byte(value))
+		return "pseudo:" + hex.EncodeToString(mac.Sum(nil)[:8])
+	case ActionDrop:
+		return ""
+	default:
+		return rr.Mask
+	}
+}
+
+// redact returns the text with the redacted values. Dropped values take one of their surrounding whitespaces with
+// them, so no double whitespace is left.
+func (rr *RedactionRule) redact(text string) string {
+	matches := rr.Detector.Expr.FindAllStringSubmatchIndex(text, -1)
+	if matches == nil {
+		return text
+	}
+	group := rr.Detector.Expr.SubexpIndex(valueGroup)
+	var result strings.Builder
+	last := 0
+	replaced := false
+	for _, match := range matches {
+		start, end := match[0], match[1]
+		if group > 0 {
+			start, end = match[2*group], match[2*group+1]
+			if start < 0 {
+				continue
+			}
+		}
+		value := text[start:end]
+		if rr.Detector.Validate != nil && !rr.Detector.Validate(value) {
+			continue
+		}
+		prefix := text[last:start]
+		replacement := rr.replacement(value)
+		if replacement == "" {
+			prefix, end = collapseWhitespace(text, prefix, start, end)
+		}
+		result.WriteString(prefix)
+		result.WriteString(replacement)
+		last = end
+		replaced = true
+		atomic.AddUint64(&rr.count, 1)
+	}
+	if !replaced {
+		return text
+	}
+	result.WriteString(text[last:])
+	return result.String()
+}
+
+// collapseWhitespace removes the whitespace after the dropped value at start:end of the text if it starts the text
+// or follows a whitespace. A dropped value at the end of the text removes the whitespace before it instead. It
+// returns the prefix of the value and the new end.
+func collapseWhitespace(text string, prefix string, start int, end int) (string, int) {
+	rest := strings.TrimLeftFunc(text[end:], unicode.IsSpace)
+	switch {
+	case rest == "":
+		return strings.TrimRightFunc(prefix, unicode.IsSpace), len(text)
+	case start == 0 || strings.TrimRightFunc(text[:start], unicode.IsSpace) != text[:start]:
+		return prefix, len(text) - len(rest)
+	}
+	return prefix, end
+}
+
+// Redactor applies the redaction rules in order to the messages and tag values of the logs. Tags whose values are
+// empty after the redaction are removed.
+type Redactor struct {
+	Rules []*RedactionRule
+}
+
+func (r *Redactor) Process(logs []*api.Log) []*api.Log {
+	for _, log := range logs {
+		for _, rule := range r.Rules {
+			log.Message = rule.redact(log.Message)
+			for name, value := range log.Tags {
+				redacted := rule.redact(value)
+				if redacted == value {
+					continue
+				}
+				if redacted == "" {
+					delete(log.Tags, name)
+				} else {
+					log.Tags[name] = redacted
+				}
+			}
+		}
+	}
+	return logs
+}
+
+// Counts returns the number of redacted values per rule
+func (r *Redactor) Counts() map[string]uint64 {
+	counts := make(map[string]uint64, len(r.Rules))
+	for _, rule := range r.Rules {
+		counts[rule.Name] += rule.Count()
+	}
+	return counts
+}
+
+func (r *Redactor) String() string {
+	names := make([]string, len(r.Rules))
+	for i, rule := range r.Rules {
+		names[i] = fmt.Sprintf("%v (%v)", rule.Name, rule.Action)
+	}
+	return fmt.Sprintf("redactor with rules %v", strings.Join(names, ", "))
+}
@@ -0,0 +1,164 @@
+package processor
+
+import (
+	"fmt"
+	"github.com/aiops/logsight-filebeat/plugin/api"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SummaryTag marks the summary logs of the processors. Its value is the name of the processor.
+const SummaryTag = "logsight_summary"
+
+// DefaultKeepLevels are the levels which are never sampled by default
+var DefaultKeepLevels = []string{"WARNING", "WARN", "ERROR", "ERR", "EXCEPTION", "SEVERE"}
+
+// Sampler keeps all logs with one of the KeepLevels. Of the other logs, it keeps one out of Every logs per level if
+// Every is set, otherwise it keeps logs with the probability Rate if it is set. The logs of each application, as
+// given by the ApplicationTag, are further limited to MaxPerMinute if it is set.
+// The number of sampled out logs is sent as one summary log per application every SummaryInterval, with the next logs
+// or when the summaries are flushed.
+type Sampler struct {
+	KeepLevels      map[string]bool
+	Rate            float64
+	Every           int
+	MaxPerMinute    int
+	ApplicationTag  string
+	SummaryInterval time.Duration
+
+	mutex sync.Mutex
+	// seen counts the logs per level for Every
+	seen map[string]int
+	// minute is the start of the minute of kept, which counts the kept logs per application for MaxPerMinute
+	minute time.Time
+	kept   map[string]int
+	// sampledOut counts the sampled out logs per application and level since lastSummary
+	sampledOut  map[string]map[string]int
+	lastSummary time.Time
+
+	now    func() time.Time
+	random func() float64
+}
+
+func NewSampler(keepLevels []string, rate float64, every int, maxPerMinute int, applicationTag string,
+	summaryInterval time.Duration) *Sampler {
+	levels := make(map[string]bool, len(keepLevels))
+	for _, level := range keepLevels {
+		levels[strings.ToUpper(level)] = true
+	}
+	return &Sampler{
+		KeepLevels:      levels,
+		Rate:            rate,
+		Every:           every,
+		MaxPerMinute:    maxPerMinute,
+		ApplicationTag:  applicationTag,
+		SummaryInterval: summaryInterval,
+		seen:            map[string]int{},
+		kept:            map[string]int{},
+		sampledOut:      map[string]map[string]int{},
+		lastSummary:     time.Now(),
+		now:             time.Now,
+		random:          rand.Float64,
+	}
+}
+
+func (s *Sampler) Process(logs []*api.Log) []*api.Log {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := s.now()
+	if minute := now.Truncate(time.Minute); !minute.Equal(s.minute) {
+		s.minute = minute
+		s.kept = map[string]int{}
+	}
+	var kept []*api.Log
+	for _, log := range logs {
+		if s.keep(log) {
+			kept = append(kept, log)
+		}
+	}
+	if s.SummaryInterval > 0 && now.Sub(s.lastSummary) >= s.SummaryInterval {
+		kept = append(kept, s.summaries(now)...)
+	}
+	return kept
+}
+
+// Flush returns the summaries if they are due, or regardless of the SummaryInterval if final is set
+func (s *Sampler) Flush(final bool) []*api.Log {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := s.now()
+	if final || (s.SummaryInterval > 0 && now.Sub(s.lastSummary) >= s.SummaryInterval) {
+		return s.summaries(now)
+	}
+	return nil
+}
+
+func (s *Sampler) keep(log *api.Log) bool {
+	if s.KeepLevels[log.Level] {
+		return true
+	}
+	application := log.Tags[s.ApplicationTag]
+	keep := true
+	if s.Every > 0 {
+		keep = s.seen[log.Level]%s.Every == 0
+		s.seen[log.Level]++
+	} else if s.Rate > 0 {
+		keep = s.random() < s.Rate
+	}
+	if keep && s.MaxPerMinute > 0 {
+		keep = s.kept[application] < s.MaxPerMinute
+	}
+	if keep {
+		s.kept[application]++
+		return true
+	}
+	if s.sampledOut[application] == nil {
+		s.sampledOut[application] = map[string]int{}
+	}
+	s.sampledOut[application][log.Level]++
+	return false
+}
+
+// summaries returns a log per application with the number of sampled out logs in total and per level since the last
+// summaries
+func (s *Sampler) summaries(now time.Time) []*api.Log {
+	applications := make([]string, 0, len(s.sampledOut))
+	for application := range s.sampledOut {
+		applications = append(applications, application)
+	}
+	sort.Strings(applications)
+
+	summaries := make([]*api.Log, len(applications))
+	for i, application := range applications {
+		total := 0
+		tags := map[string]string{SummaryTag: "sampling"}
+		for level, count := range s.sampledOut[application] {
+			total += count
+			tags["sampled_out_"+strings.ToLower(level)] = strconv.Itoa(count)
+		}
+		tags["sampled_out"] = strconv.Itoa(total)
+		if application != "" {
+			tags[s.ApplicationTag] = application
+		}
+		summaries[i] = &api.Log{
+			Timestamp: now.UTC().Format(time.RFC3339Nano),
+			Message:   fmt.Sprintf("sampled out %v logs in the last %v", total, now.Sub(s.lastSummary).Round(time.Second)),
+			Level:     "INFO",
+			Tags:      tags,
+		}
+	}
+	s.sampledOut = map[string]map[string]int{}
+	s.lastSummary = now
+	return summaries
+}
+
+func (s *Sampler) String() string {
+	return fmt.Sprintf("sampler with rate %v, every %v and at most %v logs per minute", s.Rate, s.Every,
+		s.MaxPerMinute)
+}
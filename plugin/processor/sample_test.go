@@ -0,0 +1,123 @@
+package processor
+
+import (
+	"github.com/aiops/logsight-filebeat/plugin/api"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func sampleLogs(level string, application string, n int) []*api.Log {
+	logs := make([]*api.Log, n)
+	for i := range logs {
+		logs[i] = &api.Log{
+			Timestamp: "2022-04-04T09:00:35Z",
+			Message:   "message",
+			Level:     level,
+			Tags:      map[string]string{"application": application},
+		}
+	}
+	return logs
+}
+
+func TestSampler_Process(t *testing.T) {
+	tests := []struct {
+		name         string
+		rate         float64
+		every        int
+		maxPerMinute int
+		logs         []*api.Log
+		want         int
+	}{
+		{name: "pass keep levels", every: 10, logs: sampleLogs("ERROR", "shop", 20), want: 20},
+		{name: "pass every", every: 10, logs: sampleLogs("DEBUG", "shop", 20), want: 2},
+		{name: "pass rate", rate: 0.5, logs: sampleLogs("INFO", "shop", 20), want: 10},
+		{name: "pass max per minute", maxPerMinute: 5, logs: sampleLogs("INFO", "shop", 20), want: 5},
+		{
+			name:         "pass max per minute per application",
+			maxPerMinute: 5,
+			logs:         append(sampleLogs("INFO", "shop", 10), sampleLogs("INFO", "cart", 10)...),
+			want:         10,
+		},
+		{
+			name:         "pass max per minute does not limit keep levels",
+			maxPerMinute: 5,
+			logs:         sampleLogs("WARN", "shop", 20),
+			want:         20,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sampler := NewSampler(DefaultKeepLevels, tt.rate, tt.every, tt.maxPerMinute, "application", 0)
+			// alternates between 0 and 0.5, so half of the logs are kept with rate 0.5
+			draws := 0
+			sampler.random = func() float64 {
+				draws++
+				return float64(draws%2) * 0.5
+			}
+			assert.Len(t, sampler.Process(tt.logs), tt.want)
+		})
+	}
+}
+
+func TestSampler_Process_summary(t *testing.T) {
+	now := time.Date(2022, 4, 4, 9, 0, 10, 0, time.UTC)
+	sampler := NewSampler(DefaultKeepLevels, 0, 0, 2, "application", time.Minute)
+	sampler.now = func() time.Time { return now }
+	sampler.lastSummary = now
+
+	kept := sampler.Process(append(sampleLogs("INFO", "shop", 5), sampleLogs("DEBUG", "shop", 1)...))
+	assert.Len(t, kept, 2)
+
+	// The next minute resets the limit and the summary is due
+	now = now.Add(time.Minute)
+	kept = sampler.Process(sampleLogs("INFO", "cart", 3))
+	if assert.Len(t, kept, 4) {
+		// The summaries are sorted by application
+		assert.Equal(t, "cart", kept[2].Tags["application"])
+		assert.Equal(t, "1", kept[2].Tags["sampled_out"])
+		assert.Equal(t, &api.Log{
+			Timestamp: "2022-04-04T09:01:10Z",
+			Message:   "sampled out 4 logs in the last 1m0s",
+			Level:     "INFO",
+			Tags: map[string]string{
+				SummaryTag:          "sampling",
+				"application":       "shop",
+				"sampled_out":       "4",
+				"sampled_out_info":  "3",
+				"sampled_out_debug": "1",
+			},
+		}, kept[3])
+	}
+
+	// The counts are reset after the summary
+	now = now.Add(2 * time.Minute)
+	assert.Len(t, sampler.Process(sampleLogs("ERROR", "cart", 1)), 1)
+}
+
+func TestSampler_Flush(t *testing.T) {
+	now := time.Date(2022, 4, 4, 9, 0, 10, 0, time.UTC)
+	sampler := NewSampler(DefaultKeepLevels, 0, 10, 0, "application", time.Minute)
+	sampler.now = func() time.Time { return now }
+	sampler.lastSummary = now
+
+	sampler.Process(sampleLogs("INFO", "shop", 3))
+	assert.Len(t, sampler.Flush(false), 0, "the summary is not due yet")
+
+	// The application stopped logging, the summary is sent without further logs
+	now = now.Add(time.Minute)
+	summaries := sampler.Flush(false)
+	if assert.Len(t, summaries, 1) {
+		assert.Equal(t, "2", summaries[0].Tags["sampled_out"])
+	}
+
+	now = now.Add(time.Second)
+	sampler.Process(sampleLogs("INFO", "shop", 2))
+	assert.Len(t, sampler.Flush(false), 0)
+	summaries = sampler.Flush(true)
+	if assert.Len(t, summaries, 1) {
+		assert.Equal(t, "2", summaries[0].Tags["sampled_out"])
+	}
+	assert.Len(t, sampler.Flush(true), 0)
+}
@@ -0,0 +1,269 @@
+// Package spool persists batches of logs on disk while the Logsight API is unreachable.
+package spool
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aiops/logsight-filebeat/plugin/api"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	batchExt = ".json"
+	tmpExt   = ".tmp"
+)
+
+// Spool stores batches of logs in a directory. Every batch is written to its own file which is named by a sequence
+// number and the number of logs in the batch, so batches are read back in the order they were appended, also
+// after a restart. If the total size exceeds the max size, the oldest batches are evicted.
+type Spool struct {
+	dir     string
+	maxSize int64
+
+	mutex   sync.Mutex
+	entries []entry
+	size    int64
+	nextSeq uint64
+}
+
+type entry struct {
+	seq   uint64
+	count int
+	size  int64
+}
+
+func (e entry) fileName() string {
+	return fmt.Sprintf("%020d-%d%v", e.seq, e.count, batchExt)
+}
+
+func parseFileName(name string) (entry, bool) {
+	if !strings.HasSuffix(name, batchExt) {
+		return entry{}, false
+	}
+	parts := strings.Split(strings.TrimSuffix(name, batchExt), "-")
+	if len(parts) != 2 {
+		return entry{}, false
+	}
+	seq, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return entry{}, false
+	}
+	count, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return entry{}, false
+	}
+	return entry{seq: seq, count: count}, true
+}
+
+// Open creates the spool directory if needed and restores the batches which are already stored in it.
+func Open(dir string, maxSize int64) (*Spool, error) {
+	if maxSize <= 0 {
+		return nil, fmt.Errorf("invalid spool max size %v. must be greater than 0", maxSize)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("%w; failed to create spool directory %v", err, dir)
+	}
+	s := &Spool{dir: dir, maxSize: maxSize}
+	if err := s.restore(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Spool) restore() error {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return fmt.Errorf("%w; failed to read spool directory %v", err, s.dir)
+	}
+	for _, file := range files {
+		name := file.Name()
+		if strings.HasSuffix(name, tmpExt) {
+			// Incomplete write of a previous run
+			_ = os.Remove(filepath.Join(s.dir, name))
+			continue
+		}
+		e, ok := parseFileName(name)
+		if !ok || file.IsDir() {
+			continue
+		}
+		e.size = file.Size()
+		s.entries = append(s.entries, e)
+	}
+	// A batch which was replaced by fewer logs is stored twice if the run ended before the old file was removed
+	sort.Slice(s.entries, func(i, j int) bool {
+		if s.entries[i].seq == s.entries[j].seq {
+			return s.entries[i].count < s.entries[j].count
+		}
+		return s.entries[i].seq < s.entries[j].seq
+	})
+	var entries []entry
+	for _, e := range s.entries {
+		if len(entries) > 0 && entries[len(entries)-1].seq == e.seq {
+			_ = os.Remove(filepath.Join(s.dir, e.fileName()))
+			continue
+		}
+		entries = append(entries, e)
+		s.size += e.size
+	}
+	s.entries = entries
+	if len(s.entries) > 0 {
+		s.nextSeq = s.entries[len(s.entries)-1].seq + 1
+	}
+	return nil
+}
+
+// Append stores the batch as the newest batch. The batch id is stored as well, so that it stays the same when the
+// batch is sent later. The number of logs which were evicted to stay within the max size is returned.
+func (s *Spool) Append(batch *api.LogBatch) (int, error) {
+	data, err := json.Marshal(batch)
+	if err != nil {
+		return 0, fmt.Errorf("%w; failed to encode batch of %v logs", err, len(batch.Logs))
+	}
+	if int64(len(data)) > s.maxSize {
+		return 0, fmt.Errorf("batch of %v bytes exceeds the spool max size of %v bytes", len(data), s.maxSize)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	e := entry{seq: s.nextSeq, count: len(batch.Logs), size: int64(len(data))}
+	if err := s.write(e, data); err != nil {
+		return 0, err
+	}
+	s.nextSeq++
+	s.entries = append(s.entries, e)
+	s.size += e.size
+
+	evicted := 0
+	for s.size > s.maxSize && len(s.entries) > 1 {
+		oldest := s.entries[0]
+		if err := s.remove(); err != nil {
+			return evicted, err
+		}
+		evicted += oldest.count
+	}
+	return evicted, nil
+}
+
+// write stores the data in a temporary file first, so that a crash never leaves an incomplete batch behind.
+func (s *Spool) write(e entry, data []byte) error {
+	path := filepath.Join(s.dir, e.fileName())
+	tmpPath := path + tmpExt
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("%w; failed to create spool file", err)
+	}
+	if _, err := file.Write(data); err != nil {
+		_ = file.Close()
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("%w; failed to write spool file", err)
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("%w; failed to sync spool file", err)
+	}
+	if err := file.Close(); err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("%w; failed to close spool file", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("%w; failed to rename spool file", err)
+	}
+	return nil
+}
+
+// Peek returns the oldest batch without removing it.
+func (s *Spool) Peek() (*api.LogBatch, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.entries) == 0 {
+		return nil, fmt.Errorf("spool is empty")
+	}
+	path := filepath.Join(s.dir, s.entries[0].fileName())
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w; failed to read spool file", err)
+	}
+	var batch api.LogBatch
+	if err := json.Unmarshal(data, &batch); err != nil {
+		return nil, fmt.Errorf("%w; failed to decode spool file %v", err, path)
+	}
+	return &batch, nil
+}
+
+// ReplaceOldest stores the batch instead of the oldest batch, e.g. after logs were removed from it. The batch must not
+// contain more logs than before.
+func (s *Spool) ReplaceOldest(batch *api.LogBatch) error {
+	data, err := json.Marshal(batch)
+	if err != nil {
+		return fmt.Errorf("%w; failed to encode batch of %v logs", err, len(batch.Logs))
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.entries) == 0 {
+		return fmt.Errorf("spool is empty")
+	}
+	oldest := s.entries[0]
+	if len(batch.Logs) > oldest.count {
+		return fmt.Errorf("batch of %v logs replaces a spooled batch of %v logs", len(batch.Logs), oldest.count)
+	}
+	e := entry{seq: oldest.seq, count: len(batch.Logs), size: int64(len(data))}
+	if err := s.write(e, data); err != nil {
+		return err
+	}
+	if e.fileName() != oldest.fileName() {
+		if err := os.Remove(filepath.Join(s.dir, oldest.fileName())); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("%w; failed to remove spool file", err)
+		}
+	}
+	s.entries[0] = e
+	s.size += e.size - oldest.size
+	return nil
+}
+
+// Pop removes the oldest batch.
+func (s *Spool) Pop() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.entries) == 0 {
+		return fmt.Errorf("spool is empty")
+	}
+	return s.remove()
+}
+
+func (s *Spool) remove() error {
+	oldest := s.entries[0]
+	path := filepath.Join(s.dir, oldest.fileName())
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("%w; failed to remove spool file", err)
+	}
+	s.entries = s.entries[1:]
+	s.size -= oldest.size
+	return nil
+}
+
+// Len returns the number of stored batches.
+func (s *Spool) Len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.entries)
+}
+
+// Size returns the total size of all stored batches in bytes.
+func (s *Spool) Size() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.size
+}
@@ -0,0 +1,202 @@
+package spool
+
+import (
+	"fmt"
+	"github.com/aiops/logsight-filebeat/plugin/api"
+	"github.com/google/uuid"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testBatch(name string, size int) *api.LogBatch {
+	logs := make([]*api.Log, size)
+	for i := range logs {
+		logs[i] = &api.Log{
+			Timestamp: "2022-04-04T09:00:35+00:00",
+			Message:   fmt.Sprintf("%v %v", name, i),
+			Level:     "INFO",
+			Tags:      map[string]string{"batch": name},
+		}
+	}
+	// The id is derived from the name, so that batches can be compared
+	return &api.LogBatch{Id: uuid.NewSHA1(uuid.NameSpaceOID, []byte(name)), Logs: logs}
+}
+
+func TestSpool_AppendPeekPop(t *testing.T) {
+	s, err := Open(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	batches := []*api.LogBatch{testBatch("first", 2), testBatch("second", 1), testBatch("third", 3)}
+	for _, batch := range batches {
+		if _, err := s.Append(batch); err != nil {
+			t.Fatalf("Append() error = %v", err)
+		}
+	}
+	assert.Equal(t, len(batches), s.Len())
+
+	for _, want := range batches {
+		got, err := s.Peek()
+		if err != nil {
+			t.Fatalf("Peek() error = %v", err)
+		}
+		assert.Equal(t, want, got)
+		if err := s.Pop(); err != nil {
+			t.Fatalf("Pop() error = %v", err)
+		}
+	}
+	assert.Equal(t, 0, s.Len())
+	assert.Equal(t, int64(0), s.Size())
+
+	if _, err := s.Peek(); err == nil {
+		t.Errorf("Peek() on empty spool error = nil, want error")
+	}
+	if err := s.Pop(); err == nil {
+		t.Errorf("Pop() on empty spool error = nil, want error")
+	}
+}
+
+func TestSpool_restore(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := Open(dir, 1<<20)
+	_, _ = s.Append(testBatch("first", 1))
+	_, _ = s.Append(testBatch("second", 1))
+	size := s.Size()
+
+	// leftovers of an incomplete write and unrelated files must be ignored
+	_ = ioutil.WriteFile(filepath.Join(dir, "00000000000000000009-1.json.tmp"), []byte("[{"), 0600)
+	_ = ioutil.WriteFile(filepath.Join(dir, "unrelated.txt"), []byte("unrelated"), 0600)
+
+	restored, err := Open(dir, 1<<20)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	assert.Equal(t, 2, restored.Len())
+	assert.Equal(t, size, restored.Size())
+	got, _ := restored.Peek()
+	assert.Equal(t, testBatch("first", 1), got)
+
+	// new batches are appended after the restored ones
+	_, _ = restored.Append(testBatch("third", 1))
+	_ = restored.Pop()
+	_ = restored.Pop()
+	got, _ = restored.Peek()
+	assert.Equal(t, testBatch("third", 1), got)
+
+	if _, err := os.Stat(filepath.Join(dir, "00000000000000000009-1.json.tmp")); !os.IsNotExist(err) {
+		t.Errorf("Open() did not remove the incomplete spool file")
+	}
+}
+
+func TestSpool_ReplaceOldest(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := Open(dir, 1<<20)
+	_, _ = s.Append(testBatch("first", 3))
+	_, _ = s.Append(testBatch("second", 1))
+
+	replaced := testBatch("first", 3)
+	replaced.Remove([]int{1})
+	if err := s.ReplaceOldest(replaced); err != nil {
+		t.Fatalf("ReplaceOldest() error = %v", err)
+	}
+	assert.Error(t, s.ReplaceOldest(testBatch("first", 4)), "a replacement must not add logs")
+	got, _ := s.Peek()
+	assert.Equal(t, replaced.Logs, got.Logs)
+
+	// The replaced batch is restored once
+	restored, err := Open(dir, 1<<20)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	assert.Equal(t, 2, restored.Len())
+	assert.Equal(t, s.Size(), restored.Size())
+	got, _ = restored.Peek()
+	assert.Equal(t, replaced.Logs, got.Logs)
+}
+
+func TestSpool_restore_replaced(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := Open(dir, 1<<20)
+	_, _ = s.Append(testBatch("first", 2))
+	original, _ := ioutil.ReadFile(filepath.Join(dir, "00000000000000000000-2.json"))
+	_ = s.ReplaceOldest(testBatch("first", 1))
+
+	// The run ended before the file of the original batch was removed
+	_ = ioutil.WriteFile(filepath.Join(dir, "00000000000000000000-2.json"), original, 0600)
+	restored, _ := Open(dir, 1<<20)
+	assert.Equal(t, 1, restored.Len())
+	got, _ := restored.Peek()
+	assert.Len(t, got.Logs, 1)
+	if _, err := os.Stat(filepath.Join(dir, "00000000000000000000-2.json")); !os.IsNotExist(err) {
+		t.Errorf("Open() did not remove the file of the replaced batch")
+	}
+}
+
+func TestSpool_evict(t *testing.T) {
+	batchSize := func(batch *api.LogBatch) int64 {
+		s, _ := Open(t.TempDir(), 1<<20)
+		_, _ = s.Append(batch)
+		return s.Size()
+	}
+	// All batches of the test have the same size
+	size := batchSize(testBatch("batch0", 2))
+
+	tests := []struct {
+		name        string
+		maxSize     int64
+		batches     int
+		wantLen     int
+		wantEvicted int
+		wantErr     bool
+	}{
+		{name: "pass no eviction", maxSize: 3 * size, batches: 3, wantLen: 3, wantEvicted: 0, wantErr: false},
+		{name: "pass evict oldest", maxSize: 2 * size, batches: 3, wantLen: 2, wantEvicted: 2, wantErr: false},
+		{name: "pass keep newest", maxSize: size, batches: 3, wantLen: 1, wantEvicted: 4, wantErr: false},
+		{name: "fail batch too large", maxSize: size - 1, batches: 1, wantLen: 0, wantEvicted: 0, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := Open(t.TempDir(), tt.maxSize)
+			evicted := 0
+			var err error
+			for i := 0; i < tt.batches; i++ {
+				var n int
+				n, err = s.Append(testBatch(fmt.Sprintf("batch%v", i), 2))
+				evicted += n
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Append() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			assert.Equal(t, tt.wantLen, s.Len())
+			assert.Equal(t, tt.wantEvicted, evicted)
+		})
+	}
+}
+
+func TestOpen(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "file")
+	_ = ioutil.WriteFile(file, []byte(""), 0600)
+
+	tests := []struct {
+		name    string
+		dir     string
+		maxSize int64
+		wantErr bool
+	}{
+		{name: "pass new directory", dir: filepath.Join(t.TempDir(), "spool"), maxSize: 1, wantErr: false},
+		{name: "fail invalid max size", dir: t.TempDir(), maxSize: 0, wantErr: true},
+		{name: "fail directory is a file", dir: file, maxSize: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Open(tt.dir, tt.maxSize); (err != nil) != tt.wantErr {
+				t.Errorf("Open() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}